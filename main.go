@@ -3,13 +3,17 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
+	"reflect"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
 
 	_ "github.com/lib/pq"
 
@@ -26,10 +30,13 @@ import (
 	"github.com/alphagov/paas-rds-metric-collector/pkg/collector"
 	"github.com/alphagov/paas-rds-metric-collector/pkg/config"
 	"github.com/alphagov/paas-rds-metric-collector/pkg/emitter"
+	"github.com/alphagov/paas-rds-metric-collector/pkg/reloader"
 	"github.com/alphagov/paas-rds-metric-collector/pkg/scheduler"
+	"github.com/alphagov/paas-rds-metric-collector/pkg/status"
 	uuid "github.com/satori/go.uuid"
 	"github.com/tedsuo/ifrit"
 	"github.com/tedsuo/ifrit/grouper"
+	"github.com/tedsuo/ifrit/http_server"
 	"github.com/tedsuo/ifrit/sigmon"
 )
 
@@ -47,8 +54,6 @@ var (
 
 const (
 	ConnectionTimeout = 10
-	MysqlTLS          = "skip-verify"
-	PostgresSSLMode   = "require"
 )
 
 func init() {
@@ -58,99 +63,300 @@ func init() {
 
 var logger = lager.NewLogger("rds-metric-collector")
 
-func initLogger(logLevel string) lager.Logger {
+// logSink lets the log level be changed when the config is reloaded
+var logSink *lager.ReconfigurableSink
+
+func parseLogLevel(logLevel string) (lager.LogLevel, error) {
 	laggerLogLevel, ok := logLevels[strings.ToUpper(logLevel)]
 	if !ok {
-		log.Fatal("Invalid log level: ", logLevel)
+		return lager.INFO, fmt.Errorf("Invalid log level: %s", logLevel)
+	}
+	return laggerLogLevel, nil
+}
+
+func initLogger(logLevel string, w io.Writer) lager.Logger {
+	laggerLogLevel, err := parseLogLevel(logLevel)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	logger.RegisterSink(lager.NewWriterSink(os.Stdout, laggerLogLevel))
+	logSink = lager.NewReconfigurableSink(lager.NewWriterSink(w, lager.DEBUG), laggerLogLevel)
+	logger.RegisterSink(logSink)
 
 	return logger
 }
 
 func main() {
+	flag.Usage = usage
 	flag.Parse()
 
+	switch flag.Arg(0) {
+	case "":
+		run()
+	case "validate":
+		os.Exit(validate())
+	case "once":
+		if flag.NArg() != 2 {
+			usage()
+			os.Exit(2)
+		}
+		os.Exit(collectOnce(flag.Arg(1)))
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown mode: %s\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s [flags] [mode]
+
+Modes:
+  (none)                 collect the metrics of every instance while holding the lock
+  validate               check the config and list every problem found
+  once <instance-guid>   collect the metrics of one instance once and print them
+
+Flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+func run() {
 	cfg, err := config.LoadConfig(configFilePath)
 	if err != nil {
 		log.Fatal(fmt.Sprintf("Error loading config file: '%s'. ", configFilePath), err)
 	}
-	initLogger(cfg.LogLevel)
+	initLogger(cfg.LogLevel, os.Stdout)
+
+	awsSession := session.New(aws.NewConfig().WithRegion(cfg.AWS.Region))
+	brokerInfo := createBrokerInfo(cfg, awsSession)
+
+	metricsEmitter, members := createMetricsEmitter(logger, cfg)
+
+	drivers, err := createCollectorDrivers(cfg, brokerInfo, awsSession)
+	if err != nil {
+		logger.Fatal("creating-collector-drivers", err)
+	}
+
+	scheduler := scheduler.NewScheduler(
+		cfg.Scheduler,
+		brokerInfo,
+		metricsEmitter,
+		logger.Session("scheduler"),
+	)
+	scheduler.WithDriver(drivers.all()...)
+	scheduler.SetMetricTags(cfg.MetricTags)
+
+	locketRunner := status.NewReadinessTracker(createLocketRunner(logger, cfg))
+
+	if cfg.OperatorAPI.ListenAddress != "" {
+		members = append(members, grouper.Member{
+			Name:   "operatorAPI",
+			Runner: createOperatorAPIRunner(cfg, locketRunner, scheduler, metricsEmitter),
+		})
+	}
+
+	members = append(members, grouper.Member{Name: "locketRunner", Runner: locketRunner})
+	members = append(members, grouper.Member{Name: "scheduleRunner", Runner: scheduler})
+
+	group := grouper.NewOrdered(os.Interrupt, members)
+
+	reload := func() error {
+		newCfg, err := reloadConfig(cfg, scheduler, drivers)
+		if err != nil {
+			return err
+		}
+		cfg = newCfg
+		return nil
+	}
+
+	monitor := ifrit.Invoke(sigmon.New(
+		reloader.New(group, reload, logger.Session("reloader")),
+		reloader.ReloadSignal,
+	))
+	err = <-monitor.Wait()
+
+	if err != nil {
+		logger.Error("process-group-stopped-with-error", err)
+		os.Exit(1)
+	}
+}
+
+func createBrokerInfo(cfg *config.Config, awsSession *session.Session) brokerinfo.BrokerInfo {
+	if cfg.BrokerInfo == "static" {
+		return brokerinfo.NewStaticBrokerInfo(
+			cfg.StaticBrokerInfo,
+			logger.Session("brokerinfo"),
+		)
+	}
 
-	awsConfig := aws.NewConfig().WithRegion(cfg.AWS.Region)
-	awsSession := session.New(awsConfig)
 	rdssvc := rds.New(awsSession)
 	dbInstance := awsrds.NewRDSDBInstance(cfg.AWS.Region, "aws", rdssvc, logger, 604800, nil)
 
-	rdsBrokerInfo := brokerinfo.NewRDSBrokerInfo(
+	return brokerinfo.NewRDSBrokerInfo(
 		cfg.RDSBrokerInfo,
 		dbInstance,
+		secretsmanager.New(awsSession),
 		logger.Session("brokerinfo", lager.Data{"broker_name": cfg.RDSBrokerInfo.BrokerName}),
 	)
+}
 
-	var metricsEmitter emitter.MetricsEmitter
-	if useStdoutEmitter {
-		metricsEmitter = &emitter.StdOutEmitter{}
-	} else {
-		metricsEmitter, err = emitter.NewLoggregatorEmitter(
-			cfg.LoggregatorEmitter,
-			logger.Session("loggregator_emitter", lager.Data{"url": cfg.LoggregatorEmitter.MetronURL}),
-		)
-		if err != nil {
-			logger.Error("connecting to loggregator", err)
-			os.Exit(1)
-		}
+// collectorDrivers are the drivers of the collector, split by the config
+// setting of their collection interval. There is no CloudWatch driver for
+// instances outside RDS.
+type collectorDrivers struct {
+	sql        []collector.MetricsCollectorDriver
+	cloudWatch collector.MetricsCollectorDriver
+}
+
+func (d collectorDrivers) all() []collector.MetricsCollectorDriver {
+	drivers := append([]collector.MetricsCollectorDriver{}, d.sql...)
+	if d.cloudWatch != nil {
+		drivers = append(drivers, d.cloudWatch)
+	}
+	return drivers
+}
+
+func createCollectorDrivers(cfg *config.Config, brokerInfo brokerinfo.BrokerInfo, awsSession *session.Session) (collectorDrivers, error) {
+	authTokenBuilder := collector.NewRDSAuthTokenBuilder(cfg.AWS.Region, awsSession.Config.Credentials)
+
+	mysqlTLS, err := collector.MysqlTLSParam(cfg.MysqlCollector.TLSMode, cfg.DBCABundleFile)
+	if err != nil {
+		return collectorDrivers{}, err
 	}
 
 	postgresMetricsCollectorDriver := collector.NewPostgresMetricsCollectorDriver(
-		rdsBrokerInfo,
+		brokerInfo,
 		cfg.Scheduler.SQLMetricCollectorInterval,
 		ConnectionTimeout,
-		PostgresSSLMode,
+		cfg.DBCABundleFile,
+		cfg.PostgresCollector,
+		cfg.CustomQueries,
+		authTokenBuilder,
 		logger.Session("postgres_metrics_collector"),
 	)
 
 	mysqlMetricsCollectorDriver := collector.NewMysqlMetricsCollectorDriver(
-		rdsBrokerInfo,
+		brokerInfo,
 		cfg.Scheduler.SQLMetricCollectorInterval,
 		ConnectionTimeout,
-		MysqlTLS,
+		mysqlTLS,
+		cfg.MysqlCollector,
+		cfg.CustomQueries,
+		authTokenBuilder,
 		logger.Session("mysql_metrics_collector"),
 	)
 
-	cloudWatchMetricsCollectorDriver := collector.NewCloudWatchCollectorDriver(
-		cfg.Scheduler.CWMetricCollectorInterval,
-		awsSession,
-		rdsBrokerInfo,
-		logger.Session("cloudwatch_metrics_collector"),
-	)
+	drivers := collectorDrivers{
+		sql: []collector.MetricsCollectorDriver{
+			postgresMetricsCollectorDriver,
+			mysqlMetricsCollectorDriver,
+		},
+	}
+	if cfg.BrokerInfo == "rds" {
+		drivers.cloudWatch = collector.NewCloudWatchCollectorDriver(
+			cfg.Scheduler.CWMetricCollectorInterval,
+			awsSession,
+			brokerInfo,
+			logger.Session("cloudwatch_metrics_collector"),
+		)
+	}
+	return drivers, nil
+}
 
-	scheduler := scheduler.NewScheduler(
-		cfg.Scheduler,
-		rdsBrokerInfo,
-		metricsEmitter,
-		logger.Session("scheduler"),
-	)
-	scheduler.WithDriver(postgresMetricsCollectorDriver)
-	scheduler.WithDriver(mysqlMetricsCollectorDriver)
-	scheduler.WithDriver(cloudWatchMetricsCollectorDriver)
+// reloadConfig reads the config file again and applies the log level and the
+// scheduler settings of the new config. It returns the running config with
+// only the settings it applied changed, so that the other changes, which are
+// only applied after a restart, are still reported on the next reload. The
+// running config is kept if the new one is not valid.
+func reloadConfig(cfg *config.Config, scheduler *scheduler.Scheduler, drivers collectorDrivers) (*config.Config, error) {
+	newCfg, err := config.LoadConfig(configFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading config file: '%s': %s", configFilePath, err)
+	}
+	logLevel, err := parseLogLevel(newCfg.LogLevel)
+	if err != nil {
+		return nil, err
+	}
 
-	members := []grouper.Member{}
-	locketRunner := createLocketRunner(logger, cfg)
+	logSink.SetMinLevel(logLevel)
+	for _, driver := range drivers.sql {
+		driver.SetCollectInterval(newCfg.Scheduler.SQLMetricCollectorInterval)
+	}
+	if drivers.cloudWatch != nil {
+		drivers.cloudWatch.SetCollectInterval(newCfg.Scheduler.CWMetricCollectorInterval)
+	}
+	scheduler.Reconfigure(newCfg.Scheduler)
+	scheduler.SetMetricTags(newCfg.MetricTags)
+
+	running := *cfg
+	running.LogLevel = newCfg.LogLevel
+	running.Scheduler = newCfg.Scheduler
+	running.MetricTags = newCfg.MetricTags
+	if !reflect.DeepEqual(running, *newCfg) {
+		logger.Info("config-changes-need-restart")
+	}
 
-	members = append(members, grouper.Member{Name: "locketRunner", Runner: locketRunner})
-	members = append(members, grouper.Member{Name: "scheduleRunner", Runner: scheduler})
+	return &running, nil
+}
 
-	group := grouper.NewOrdered(os.Interrupt, members)
+func createMetricsEmitter(logger lager.Logger, cfg *config.Config) (emitter.MetricsEmitter, []grouper.Member) {
+	members := []grouper.Member{}
 
-	monitor := ifrit.Invoke(sigmon.New(group))
-	err = <-monitor.Wait()
+	emitterNames := cfg.Emitters
+	if useStdoutEmitter {
+		emitterNames = []string{"stdout"}
+	}
 
-	if err != nil {
-		logger.Error("process-group-stopped-with-error", err)
-		os.Exit(1)
+	sinks := []emitter.FanOutSink{}
+	for _, name := range emitterNames {
+		switch name {
+		case "stdout":
+			sinks = append(sinks, emitter.FanOutSink{Name: name, Emitter: &emitter.StdOutEmitter{}})
+		case "loggregator":
+			loggregatorEmitter, err := emitter.NewLoggregatorEmitter(
+				cfg.LoggregatorEmitter,
+				logger.Session("loggregator_emitter", lager.Data{"url": cfg.LoggregatorEmitter.MetronURL}),
+			)
+			if err != nil {
+				logger.Error("connecting to loggregator", err)
+				os.Exit(1)
+			}
+			sinks = append(sinks, emitter.FanOutSink{Name: name, Emitter: loggregatorEmitter})
+		case "prometheus":
+			prometheusEmitter := emitter.NewPrometheusEmitter(
+				cfg.PrometheusEmitter,
+				clock.NewClock(),
+				logger.Session("prometheus_emitter", lager.Data{"listen_address": cfg.PrometheusEmitter.ListenAddress}),
+			)
+			members = append(members, grouper.Member{Name: "prometheusEmitter", Runner: prometheusEmitter.Runner()})
+			sinks = append(sinks, emitter.FanOutSink{Name: name, Emitter: prometheusEmitter})
+		}
 	}
+
+	if len(sinks) == 1 {
+		return sinks[0].Emitter, members
+	}
+
+	fanOutEmitter := emitter.NewFanOutEmitter(sinks, 0, clock.NewClock(), logger.Session("fanout_emitter"))
+	members = append(members, grouper.Member{Name: "fanOutEmitter", Runner: fanOutEmitter})
+	return fanOutEmitter, members
+}
+
+func createOperatorAPIRunner(
+	cfg *config.Config,
+	lock status.Lock,
+	scheduler *scheduler.Scheduler,
+	metricsEmitter emitter.MetricsEmitter,
+) ifrit.Runner {
+	// Only the fan-out emitter, used with several emitters, tracks the
+	// delivery failures
+	emitters, _ := metricsEmitter.(status.Emitters)
+
+	mux := http.NewServeMux()
+	mux.Handle("/telemetry", scheduler.TelemetryHandler())
+	status.NewHandler(lock, scheduler, emitters, logger.Session("operator_api")).Register(mux)
+	return http_server.New(cfg.OperatorAPI.ListenAddress, mux)
 }
 
 func createLocketRunner(logger lager.Logger, locketConfig *config.Config) ifrit.Runner {
@@ -1,8 +1,11 @@
 package main_test
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
 	"os/exec"
+	"syscall"
 	"time"
 
 	_ "github.com/lib/pq"
@@ -44,11 +47,91 @@ var _ = Describe("collector", func() {
 		Expect(rdsMetricsCollectorSession.Err).To(gbytes.Say("invalid character"))
 	})
 
+	Context("in validate mode", func() {
+		It("succeeds if the config is valid", func() {
+			command := exec.Command(rdsMetricCollectorPath,
+				"-config="+testhelpers.BuildTempConfigFile(mockLocketServer.ListenAddress, "./fixtures"),
+				"validate",
+			)
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Eventually(session, "5s").Should(gexec.Exit(0))
+			Expect(session.Out).To(gbytes.Say("is valid"))
+		})
+
+		It("succeeds with the static broker info config", func() {
+			command := exec.Command(rdsMetricCollectorPath,
+				"-config=./fixtures/static_collector_config.json",
+				"validate",
+			)
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Eventually(session, "5s").Should(gexec.Exit(0))
+		})
+
+		It("lists every problem of the config", func() {
+			configFile, err := os.CreateTemp("", "rds-metrics-collector-config-")
+			Expect(err).ShouldNot(HaveOccurred())
+			defer os.Remove(configFile.Name())
+			_, err = configFile.WriteString(`{
+				"log_level": "LOUD",
+				"scheduler": {"instance_refresh_interval": 0},
+				"loggregator_emitter": {
+					"ca_cert": "./fixtures/invalid-cert.data",
+					"client_cert": "./fixtures/client.cert.pem",
+					"client_key": "./fixtures/client.key.pem"
+				}
+			}`)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(configFile.Close()).To(Succeed())
+
+			command := exec.Command(rdsMetricCollectorPath, "-config="+configFile.Name(), "validate")
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Eventually(session, "5s").Should(gexec.Exit(1))
+			Expect(session.Err).To(gbytes.Say("is not valid"))
+			Expect(session.Err).To(gbytes.Say("aws.region: failed the 'required' validation"))
+			Expect(session.Err).To(gbytes.Say("rds_broker.db_prefix: failed the 'required' validation"))
+			Expect(session.Err).To(gbytes.Say("scheduler.instance_refresh_interval: failed the 'required' validation"))
+			Expect(session.Err).To(gbytes.Say("Invalid log level: LOUD"))
+			Expect(session.Err).To(gbytes.Say("loggregator_emitter certificates"))
+		})
+	})
+
+	Context("in once mode", func() {
+		It("fails without an instance GUID", func() {
+			command := exec.Command(rdsMetricCollectorPath,
+				"-config="+testhelpers.BuildTempConfigFile(mockLocketServer.ListenAddress, "./fixtures"),
+				"once",
+			)
+			session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Eventually(session, "5s").Should(gexec.Exit(2))
+			Expect(session.Err).To(gbytes.Say("Usage"))
+		})
+	})
+
+	It("fails with an unknown mode", func() {
+		command := exec.Command(rdsMetricCollectorPath, "-config=unknown.json", "dance")
+		session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		Eventually(session, "5s").Should(gexec.Exit(2))
+		Expect(session.Err).To(gbytes.Say("Unknown mode: dance"))
+	})
+
 	Context("with valid configuration", func() {
+		var configFilePath string
+
 		BeforeEach(func() {
 			var err error
+			configFilePath = testhelpers.BuildTempConfigFile(mockLocketServer.ListenAddress, "./fixtures")
 			command := exec.Command(rdsMetricCollectorPath,
-				"-config="+testhelpers.BuildTempConfigFile(mockLocketServer.ListenAddress, "./fixtures"),
+				"-config="+configFilePath,
 			)
 			rdsMetricsCollectorSession, err = gexec.Start(command, GinkgoWriter, GinkgoWriter)
 			Expect(err).ShouldNot(HaveOccurred())
@@ -73,6 +156,59 @@ var _ = Describe("collector", func() {
 			rdsMetricsCollectorSession.Terminate()
 			Eventually(rdsMetricsCollectorSession, 30*time.Second).Should(gexec.Exit())
 		})
+		It("reloads the config on SIGHUP", func() {
+			Eventually(rdsMetricsCollectorSession, 10*time.Second).Should(
+				gbytes.Say("rds-metric-collector.scheduler.scheduler-started"),
+			)
+			rdsMetricsCollectorSession.Signal(syscall.SIGHUP)
+			Eventually(rdsMetricsCollectorSession, 5*time.Second).Should(
+				gbytes.Say("rds-metric-collector.scheduler.reconfigured"),
+			)
+			Eventually(rdsMetricsCollectorSession, 5*time.Second).Should(
+				gbytes.Say("rds-metric-collector.reloader.reloaded"),
+			)
+			Consistently(rdsMetricsCollectorSession, 2*time.Second).ShouldNot(gexec.Exit())
+		})
+		It("keeps reporting the changes that need a restart on every reload", func() {
+			Eventually(rdsMetricsCollectorSession, 10*time.Second).Should(
+				gbytes.Say("rds-metric-collector.scheduler.scheduler-started"),
+			)
+			contents, err := os.ReadFile(configFilePath)
+			Expect(err).NotTo(HaveOccurred())
+			var cfg map[string]interface{}
+			Expect(json.Unmarshal(contents, &cfg)).To(Succeed())
+			cfg["custom_queries"] = []map[string]interface{}{{
+				"name":    "one",
+				"engine":  "postgres",
+				"type":    "row",
+				"query":   "SELECT 1 as one",
+				"metrics": []map[string]string{{"key": "one", "unit": "count"}},
+			}}
+			contents, err = json.Marshal(cfg)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(os.WriteFile(configFilePath, contents, 0644)).To(Succeed())
+
+			for i := 0; i < 2; i++ {
+				rdsMetricsCollectorSession.Signal(syscall.SIGHUP)
+				Eventually(rdsMetricsCollectorSession, 5*time.Second).Should(
+					gbytes.Say("rds-metric-collector.config-changes-need-restart"),
+				)
+				Eventually(rdsMetricsCollectorSession, 5*time.Second).Should(
+					gbytes.Say("rds-metric-collector.reloader.reloaded"),
+				)
+			}
+		})
+		It("keeps running with the old config if the new one is invalid", func() {
+			Eventually(rdsMetricsCollectorSession, 10*time.Second).Should(
+				gbytes.Say("rds-metric-collector.scheduler.scheduler-started"),
+			)
+			Expect(os.WriteFile(configFilePath, []byte("{"), 0644)).To(Succeed())
+			rdsMetricsCollectorSession.Signal(syscall.SIGHUP)
+			Eventually(rdsMetricsCollectorSession, 5*time.Second).Should(
+				gbytes.Say("rds-metric-collector.reloader.reload-failed"),
+			)
+			Consistently(rdsMetricsCollectorSession, 2*time.Second).ShouldNot(gexec.Exit())
+		})
 	})
 
 })
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+
+	"github.com/alphagov/paas-rds-metric-collector/pkg/brokerinfo"
+	"github.com/alphagov/paas-rds-metric-collector/pkg/collector"
+	"github.com/alphagov/paas-rds-metric-collector/pkg/config"
+	"github.com/alphagov/paas-rds-metric-collector/pkg/emitter"
+	"github.com/alphagov/paas-rds-metric-collector/pkg/metrics"
+	"github.com/alphagov/paas-rds-metric-collector/pkg/utils"
+)
+
+// onceCollectTimeout is how long collectOnce waits for each collector
+const onceCollectTimeout = 60 * time.Second
+
+// validate checks the config file, its secret files and the certificates of
+// the emitters, and lists every problem found. It returns the exit code.
+func validate() int {
+	cfg, err := config.ReadConfig(configFilePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config file: '%s'. %s\n", configFilePath, err)
+		return 1
+	}
+
+	problems := cfg.Problems()
+	if _, err := parseLogLevel(cfg.LogLevel); err != nil {
+		problems = append(problems, err)
+	}
+	if err := cfg.ReadSecretFiles(); err != nil {
+		problems = append(problems, err)
+	} else if err := cfg.ValidateSecrets(); err != nil {
+		problems = append(problems, err)
+	}
+	if cfg.DBCABundleFile != "" {
+		if _, err := collector.LoadCABundle(cfg.DBCABundleFile); err != nil {
+			problems = append(problems, fmt.Errorf("db_ca_bundle_file: %s", err))
+		}
+	}
+	if cfg.HasEmitter("loggregator") && !useStdoutEmitter {
+		if err := emitter.CheckLoggregatorCerts(cfg.LoggregatorEmitter); err != nil {
+			problems = append(problems, err)
+		}
+	}
+
+	if len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "Config file '%s' is not valid:\n", configFilePath)
+		for _, problem := range problems {
+			fmt.Fprintf(os.Stderr, "  - %s\n", problem)
+		}
+		return 1
+	}
+
+	fmt.Printf("Config file '%s' is valid\n", configFilePath)
+	return 0
+}
+
+// collectOnce runs the collector of every driver once for the instance, and
+// prints the metrics to stdout. It does not take the lock, so it can run
+// next to the collectors. It returns the exit code.
+func collectOnce(instanceGUID string) int {
+	cfg, err := config.LoadConfig(configFilePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config file: '%s'. %s\n", configFilePath, err)
+		return 1
+	}
+	initLogger(cfg.LogLevel, os.Stderr)
+
+	awsSession := session.New(aws.NewConfig().WithRegion(cfg.AWS.Region))
+	brokerInfo := createBrokerInfo(cfg, awsSession)
+
+	instanceInfos, err := brokerInfo.ListInstances()
+	if err != nil {
+		logger.Error("listing-instances", err)
+		return 1
+	}
+
+	var instanceInfo *brokerinfo.InstanceInfo
+	for i := range instanceInfos {
+		if instanceInfos[i].GUID == instanceGUID {
+			instanceInfo = &instanceInfos[i]
+			break
+		}
+	}
+	if instanceInfo == nil {
+		logger.Error("instance-not-found", fmt.Errorf("instance %s not found", instanceGUID))
+		return 1
+	}
+
+	drivers, err := createCollectorDrivers(cfg, brokerInfo, awsSession)
+	if err != nil {
+		logger.Error("creating-collector-drivers", err)
+		return 1
+	}
+
+	instanceTags := instanceInfo.MetricTags(cfg.MetricTags)
+	metricsEmitter := &emitter.StdOutEmitter{}
+	exitCode := 0
+	for _, driver := range drivers.all() {
+		if !utils.SliceContainsString(driver.SupportedTypes(), instanceInfo.Type) {
+			continue
+		}
+		logData := lager.Data{"driver": driver.GetName(), "instanceGUID": instanceGUID}
+
+		metricsCollector, err := driver.NewCollector(*instanceInfo)
+		if err != nil {
+			logger.Error("failed-creating-collector", err, logData)
+			exitCode = 1
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), onceCollectTimeout)
+		collectedMetrics, err := metricsCollector.Collect(ctx)
+		cancel()
+		metricsCollector.Close()
+		if err != nil {
+			logger.Error("failed-collecting-metrics", err, logData)
+			exitCode = 1
+			continue
+		}
+
+		envelopes := make([]metrics.MetricEnvelope, 0, len(collectedMetrics))
+		for _, metric := range collectedMetrics {
+			envelope := metrics.MetricEnvelope{InstanceGUID: instanceGUID, Metric: metric}
+			envelopes = append(envelopes, envelope.WithTags(instanceTags))
+		}
+		metricsEmitter.EmitBatch(envelopes)
+		logData["metrics"] = len(collectedMetrics)
+		logger.Info("collected-metrics", logData)
+	}
+
+	return exitCode
+}
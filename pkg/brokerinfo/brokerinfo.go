@@ -1,10 +1,68 @@
 package brokerinfo
 
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/alphagov/paas-rds-metric-collector/pkg/config"
+)
+
+// InstanceInfo describes a service instance. Tags are the tags of the
+// database instance, such as the plan name, if the broker info provides them.
+// The engine version, instance class and multi-AZ status are empty if the
+// broker info does not know them.
 type InstanceInfo struct {
-	GUID string
-	Type string
+	GUID          string
+	Type          string
+	Tags          map[string]string
+	EngineVersion string
+	InstanceClass string
+	MultiAZ       *bool
+}
+
+// MetricTags returns the details of the instance to add as tags to its
+// metrics, restricted to the ones listed in the config. Unknown details are
+// left out.
+func (i InstanceInfo) MetricTags(metricTagsConfig config.MetricTagsConfig) map[string]string {
+	tags := map[string]string{}
+	for _, name := range metricTagsConfig.InstanceMetadata {
+		var value string
+		switch name {
+		case "engine":
+			value = i.Type
+		case "engine_version":
+			value = i.EngineVersion
+		case "instance_class":
+			value = i.InstanceClass
+		case "multi_az":
+			if i.MultiAZ != nil {
+				value = strconv.FormatBool(*i.MultiAZ)
+			}
+		}
+		if value != "" {
+			tags[name] = value
+		}
+	}
+	for _, key := range metricTagsConfig.InstanceTags {
+		if value, ok := i.Tags[key]; ok && value != "" {
+			tags[tagName(key)] = value
+		}
+	}
+	return tags
+}
+
+var nonTagNameCharacters = regexp.MustCompile("[^a-z0-9]+")
+
+// tagName turns the key of an instance tag into a metric tag name in snake
+// case, e.g. "Plan ID" into "plan_id"
+func tagName(key string) string {
+	return strings.Trim(nonTagNameCharacters.ReplaceAllString(strings.ToLower(key), "_"), "_")
 }
 
+// InstanceConnectionDetails are the details to connect to an instance. The
+// master username and password are the credentials of the monitoring user
+// instead, if the collector uses one.
 type InstanceConnectionDetails struct {
 	DBAddress      string
 	DBPort         int64
@@ -0,0 +1,55 @@
+package brokerinfo_test
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+
+	"github.com/alphagov/paas-rds-metric-collector/pkg/brokerinfo"
+	"github.com/alphagov/paas-rds-metric-collector/pkg/config"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("InstanceInfo", func() {
+	Context("MetricTags()", func() {
+		var instanceInfo brokerinfo.InstanceInfo
+
+		BeforeEach(func() {
+			instanceInfo = brokerinfo.InstanceInfo{
+				GUID:          "instance-id",
+				Type:          "postgres",
+				Tags:          map[string]string{"Plan ID": "plan-id", "Organization ID": "org-id"},
+				EngineVersion: "13.7",
+				InstanceClass: "db.t3.small",
+				MultiAZ:       aws.Bool(false),
+			}
+		})
+
+		It("returns the metadata and tags of the allowlist", func() {
+			tags := instanceInfo.MetricTags(config.MetricTagsConfig{
+				InstanceMetadata: []string{"engine", "engine_version", "instance_class", "multi_az"},
+				InstanceTags:     []string{"Plan ID"},
+			})
+			Expect(tags).To(Equal(map[string]string{
+				"engine":         "postgres",
+				"engine_version": "13.7",
+				"instance_class": "db.t3.small",
+				"multi_az":       "false",
+				"plan_id":        "plan-id",
+			}))
+		})
+
+		It("returns no tags by default", func() {
+			Expect(instanceInfo.MetricTags(config.MetricTagsConfig{})).To(BeEmpty())
+		})
+
+		It("leaves out the unknown details", func() {
+			instanceInfo = brokerinfo.InstanceInfo{GUID: "instance-id", Type: "mysql"}
+			tags := instanceInfo.MetricTags(config.MetricTagsConfig{
+				InstanceMetadata: []string{"engine", "engine_version", "instance_class", "multi_az"},
+				InstanceTags:     []string{"Plan ID"},
+			})
+			Expect(tags).To(Equal(map[string]string{"engine": "mysql"}))
+		})
+	})
+})
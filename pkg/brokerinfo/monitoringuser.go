@@ -0,0 +1,120 @@
+package brokerinfo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/alphagov/paas-rds-broker/utils"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+
+	"github.com/alphagov/paas-rds-metric-collector/pkg/config"
+)
+
+// monitoringCredentials returns the username and password of the dedicated
+// monitoring user of an instance
+type monitoringCredentials interface {
+	credentials(instanceGUID string) (username string, password string, err error)
+}
+
+func newMonitoringCredentials(
+	monitoringUserConfig config.MonitoringUserConfig,
+	secretsManager secretsmanageriface.SecretsManagerAPI,
+) monitoringCredentials {
+	if !monitoringUserConfig.Enabled {
+		return nil
+	}
+	switch monitoringUserConfig.PasswordSource {
+	case "file":
+		return &fileCredentials{
+			username: monitoringUserConfig.Username,
+			path:     monitoringUserConfig.PasswordsFile,
+		}
+	case "secrets_manager":
+		return &secretsManagerCredentials{
+			username:       monitoringUserConfig.Username,
+			prefix:         monitoringUserConfig.SecretNamePrefix,
+			secretsManager: secretsManager,
+		}
+	default:
+		return &seedCredentials{
+			username: monitoringUserConfig.Username,
+			seed:     monitoringUserConfig.PasswordSeed,
+		}
+	}
+}
+
+// seedCredentials derives the password from a seed and the instance GUID,
+// the same way the broker derives the master password
+type seedCredentials struct {
+	username string
+	seed     string
+}
+
+func (c *seedCredentials) credentials(instanceGUID string) (string, string, error) {
+	return c.username, utils.GenerateHash(c.seed+instanceGUID, MasterPasswordLength), nil
+}
+
+// fileCredentials reads the password from a JSON file mapping the instance
+// GUIDs to the passwords. The file is read every time, so that the passwords
+// can be changed without restarting the collector.
+type fileCredentials struct {
+	username string
+	path     string
+}
+
+func (c *fileCredentials) credentials(instanceGUID string) (string, string, error) {
+	contents, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return "", "", err
+	}
+	passwords := map[string]string{}
+	if err := json.Unmarshal(contents, &passwords); err != nil {
+		return "", "", fmt.Errorf("parsing %s: %s", c.path, err)
+	}
+	password, ok := passwords[instanceGUID]
+	if !ok {
+		return "", "", fmt.Errorf("no password for instance %s in %s", instanceGUID, c.path)
+	}
+	return c.username, password, nil
+}
+
+// secretsManagerCredentials reads the password from the AWS Secrets Manager
+// secret named after the instance GUID. The secret is either the password
+// itself or a JSON object with the password and optionally the username, as
+// in the secrets of RDS.
+type secretsManagerCredentials struct {
+	username       string
+	prefix         string
+	secretsManager secretsmanageriface.SecretsManagerAPI
+}
+
+type secretsManagerSecret struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func (c *secretsManagerCredentials) credentials(instanceGUID string) (string, string, error) {
+	secretName := c.prefix + instanceGUID
+	output, err := c.secretsManager.GetSecretValue(&secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(secretName),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	secretString := aws.StringValue(output.SecretString)
+
+	var secret secretsManagerSecret
+	if json.Unmarshal([]byte(secretString), &secret) != nil {
+		secret = secretsManagerSecret{Password: secretString}
+	}
+	if secret.Username == "" {
+		secret.Username = c.username
+	}
+	if secret.Username == "" || secret.Password == "" {
+		return "", "", fmt.Errorf("secret %s has no username or password", secretName)
+	}
+	return secret.Username, secret.Password, nil
+}
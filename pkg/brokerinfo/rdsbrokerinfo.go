@@ -10,6 +10,7 @@ import (
 
 	"github.com/alphagov/paas-rds-metric-collector/pkg/config"
 	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
 )
 
 type RDSBrokerInfo struct {
@@ -18,19 +19,27 @@ type RDSBrokerInfo struct {
 	masterPasswordSeed string
 	dbInstance         awsrds.RDSInstance
 	logger             lager.Logger
+
+	// monitoringCredentials returns the credentials of the monitoring
+	// user, if the collector does not connect as the master user
+	monitoringCredentials monitoringCredentials
 }
 
+// NewRDSBrokerInfo ... The secrets manager is only used when the password of
+// the monitoring user is read from AWS Secrets Manager.
 func NewRDSBrokerInfo(
 	brokerInfoConfig config.RDSBrokerInfoConfig,
 	dbInstance awsrds.RDSInstance,
+	secretsManager secretsmanageriface.SecretsManagerAPI,
 	logger lager.Logger,
 ) *RDSBrokerInfo {
 	return &RDSBrokerInfo{
-		brokerName:         brokerInfoConfig.BrokerName,
-		dbPrefix:           brokerInfoConfig.DBPrefix,
-		masterPasswordSeed: brokerInfoConfig.MasterPasswordSeed,
-		dbInstance:         dbInstance,
-		logger:             logger,
+		brokerName:            brokerInfoConfig.BrokerName,
+		dbPrefix:              brokerInfoConfig.DBPrefix,
+		masterPasswordSeed:    brokerInfoConfig.MasterPasswordSeed,
+		dbInstance:            dbInstance,
+		logger:                logger,
+		monitoringCredentials: newMonitoringCredentials(brokerInfoConfig.MonitoringUser, secretsManager),
 	}
 }
 
@@ -49,8 +58,12 @@ func (r *RDSBrokerInfo) ListInstances() ([]InstanceInfo, error) {
 			continue
 		}
 		instanceInfo := InstanceInfo{
-			GUID: r.dbInstanceIdentifierToServiceInstanceID(stringValue(dbDetails.DBInstanceIdentifier)),
-			Type: engine,
+			GUID:          r.dbInstanceIdentifierToServiceInstanceID(stringValue(dbDetails.DBInstanceIdentifier)),
+			Type:          engine,
+			Tags:          tagsMap(dbDetails.TagList),
+			EngineVersion: stringValue(dbDetails.EngineVersion),
+			InstanceClass: stringValue(dbDetails.DBInstanceClass),
+			MultiAZ:       boolPointer(dbDetails.MultiAZ),
 		}
 		serviceInstances = append(serviceInstances, instanceInfo)
 	}
@@ -67,13 +80,22 @@ func (r *RDSBrokerInfo) GetInstanceConnectionDetails(instanceInfo InstanceInfo)
 		return InstanceConnectionDetails{}, err
 	}
 
-	return InstanceConnectionDetails{
+	details := InstanceConnectionDetails{
 		DBAddress:      getEndpointAddress(dbInstanceDetails.Endpoint),
 		DBPort:         getEndpointPort(dbInstanceDetails.Endpoint),
 		MasterUsername: stringValue(dbInstanceDetails.MasterUsername),
 		MasterPassword: r.generateMasterPassword(instanceInfo.GUID),
 		DBName:         stringValue(dbInstanceDetails.DBName),
-	}, nil
+	}
+
+	if r.monitoringCredentials != nil {
+		details.MasterUsername, details.MasterPassword, err = r.monitoringCredentials.credentials(instanceInfo.GUID)
+		if err != nil {
+			r.logger.Error("obtaining monitoring user credentials", err, lager.Data{"brokerName": r.brokerName, "instanceInfo": instanceInfo})
+			return InstanceConnectionDetails{}, err
+		}
+	}
+	return details, nil
 }
 
 func (r *RDSBrokerInfo) GetInstanceName(instanceInfo InstanceInfo) string {
@@ -96,6 +118,27 @@ func int64Value(pointer *int64) int64 {
 	}
 }
 
+// boolPointer copies the value, so that InstanceInfo does not share it with
+// the RDS API response
+func boolPointer(pointer *bool) *bool {
+	if pointer == nil {
+		return nil
+	}
+	value := *pointer
+	return &value
+}
+
+func tagsMap(tagList []*rds.Tag) map[string]string {
+	if len(tagList) == 0 {
+		return nil
+	}
+	tags := map[string]string{}
+	for _, t := range tagList {
+		tags[stringValue(t.Key)] = stringValue(t.Value)
+	}
+	return tags
+}
+
 func getEndpointPort(endpoint *rds.Endpoint) int64 {
 	if endpoint != nil {
 		return int64Value(endpoint.Port)
@@ -2,6 +2,9 @@ package brokerinfo_test
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 
 	rdsfake "github.com/alphagov/paas-rds-broker/awsrds/fakes"
 
@@ -13,25 +16,49 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
 )
 
+// fakeSecretsManagerAPI returns the secrets by name
+type fakeSecretsManagerAPI struct {
+	secretsmanageriface.SecretsManagerAPI
+	secrets map[string]string
+}
+
+func (f *fakeSecretsManagerAPI) GetSecretValue(input *secretsmanager.GetSecretValueInput) (*secretsmanager.GetSecretValueOutput, error) {
+	secret, ok := f.secrets[aws.StringValue(input.SecretId)]
+	if !ok {
+		return nil, fmt.Errorf("secret %s not found", aws.StringValue(input.SecretId))
+	}
+	return &secretsmanager.GetSecretValueOutput{SecretString: aws.String(secret)}, nil
+}
+
 var _ = Describe("RDSBrokerInfo", func() {
 	var (
-		brokerInfo     *brokerinfo.RDSBrokerInfo
-		fakeDBInstance *rdsfake.FakeRDSInstance
+		brokerInfo         *brokerinfo.RDSBrokerInfo
+		brokerInfoConfig   config.RDSBrokerInfoConfig
+		fakeDBInstance     *rdsfake.FakeRDSInstance
+		fakeSecretsManager *fakeSecretsManagerAPI
 	)
 
 	BeforeEach(func() {
 		fakeDBInstance = &rdsfake.FakeRDSInstance{}
+		fakeSecretsManager = &fakeSecretsManagerAPI{}
+		brokerInfoConfig = config.RDSBrokerInfoConfig{
+			BrokerName:         "broker_name",
+			DBPrefix:           "dbprefix",
+			MasterPasswordSeed: "12345",
+		}
+	})
+
+	JustBeforeEach(func() {
 		brokerInfo = brokerinfo.NewRDSBrokerInfo(
-			config.RDSBrokerInfoConfig{
-				BrokerName:         "broker_name",
-				DBPrefix:           "dbprefix",
-				MasterPasswordSeed: "12345",
-			},
+			brokerInfoConfig,
 			fakeDBInstance,
+			fakeSecretsManager,
 			logger,
 		)
 	})
@@ -59,6 +86,13 @@ var _ = Describe("RDSBrokerInfo", func() {
 						},
 						DBName:         aws.String("dbprefix-db"),
 						MasterUsername: aws.String("master-username"),
+						TagList: []*rds.Tag{
+							{Key: aws.String("Broker Name"), Value: aws.String("broker_name")},
+							{Key: aws.String("Plan ID"), Value: aws.String("plan-id-2")},
+						},
+						EngineVersion:   aws.String("13.7"),
+						DBInstanceClass: aws.String("db.t3.small"),
+						MultiAZ:         aws.Bool(true),
 					},
 					{
 						DBInstanceIdentifier: aws.String("dbprefix-instance-id-3"),
@@ -93,7 +127,15 @@ var _ = Describe("RDSBrokerInfo", func() {
 			Expect(err).NotTo(HaveOccurred())
 			Expect(instances).To(ConsistOf(
 				brokerinfo.InstanceInfo{GUID: "instance-id-1", Type: "postgres"},
-				brokerinfo.InstanceInfo{GUID: "instance-id-2", Type: "postgres"},
+				brokerinfo.InstanceInfo{
+					GUID: "instance-id-2",
+					Type: "postgres",
+					Tags: map[string]string{"Broker Name": "broker_name", "Plan ID": "plan-id-2"},
+
+					EngineVersion: "13.7",
+					InstanceClass: "db.t3.small",
+					MultiAZ:       aws.Bool(true),
+				},
 				brokerinfo.InstanceInfo{GUID: "instance-id-3", Type: "mysql"},
 			))
 		})
@@ -146,6 +188,100 @@ var _ = Describe("RDSBrokerInfo", func() {
 			_, err := brokerInfo.GetInstanceConnectionDetails(brokerinfo.InstanceInfo{GUID: "instance-id", Type: "foo"})
 			Expect(err).To(HaveOccurred())
 		})
+
+		Context("with a monitoring user with a password seed", func() {
+			BeforeEach(func() {
+				brokerInfoConfig.MonitoringUser = config.MonitoringUserConfig{
+					Enabled:        true,
+					Username:       "monitoring",
+					PasswordSource: "seed",
+					PasswordSeed:   "67890",
+				}
+			})
+
+			It("returns the credentials of the monitoring user", func() {
+				details, err := brokerInfo.GetInstanceConnectionDetails(brokerinfo.InstanceInfo{GUID: "instance-id", Type: "postgres"})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(details.DBAddress).To(Equal("endpoint-address.example.com"))
+				Expect(details.MasterUsername).To(Equal("monitoring"))
+				Expect(details.MasterPassword).To(HaveLen(brokerinfo.MasterPasswordLength))
+				Expect(details.MasterPassword).ToNot(Equal("9Fs6CWnuwf0BAY3rDFAels3OXANSo0-M"))
+
+				otherDetails, err := brokerInfo.GetInstanceConnectionDetails(brokerinfo.InstanceInfo{GUID: "other-instance-id", Type: "postgres"})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(otherDetails.MasterPassword).ToNot(Equal(details.MasterPassword))
+			})
+		})
+
+		Context("with a monitoring user with a passwords file", func() {
+			var tempDir string
+
+			BeforeEach(func() {
+				var err error
+				tempDir, err = ioutil.TempDir("", "brokerinfo")
+				Expect(err).ToNot(HaveOccurred())
+				passwordsFile := filepath.Join(tempDir, "passwords.json")
+				err = ioutil.WriteFile(passwordsFile, []byte(`{"instance-id": "monitoring-password"}`), 0600)
+				Expect(err).ToNot(HaveOccurred())
+
+				brokerInfoConfig.MonitoringUser = config.MonitoringUserConfig{
+					Enabled:        true,
+					Username:       "monitoring",
+					PasswordSource: "file",
+					PasswordsFile:  passwordsFile,
+				}
+			})
+
+			AfterEach(func() {
+				os.RemoveAll(tempDir)
+			})
+
+			It("returns the password of the instance from the file", func() {
+				details, err := brokerInfo.GetInstanceConnectionDetails(brokerinfo.InstanceInfo{GUID: "instance-id", Type: "postgres"})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(details.MasterUsername).To(Equal("monitoring"))
+				Expect(details.MasterPassword).To(Equal("monitoring-password"))
+			})
+
+			It("fails if the file has no password for the instance", func() {
+				_, err := brokerInfo.GetInstanceConnectionDetails(brokerinfo.InstanceInfo{GUID: "other-instance-id", Type: "postgres"})
+				Expect(err).To(MatchError(ContainSubstring("no password for instance other-instance-id")))
+			})
+		})
+
+		Context("with a monitoring user with passwords in AWS Secrets Manager", func() {
+			BeforeEach(func() {
+				fakeSecretsManager.secrets = map[string]string{
+					"rds-monitoring/instance-id":       `{"username": "secret-monitoring", "password": "secret-password"}`,
+					"rds-monitoring/other-instance-id": "plain-password",
+				}
+				brokerInfoConfig.MonitoringUser = config.MonitoringUserConfig{
+					Enabled:          true,
+					Username:         "monitoring",
+					PasswordSource:   "secrets_manager",
+					SecretNamePrefix: "rds-monitoring/",
+				}
+			})
+
+			It("returns the credentials from the secret of the instance", func() {
+				details, err := brokerInfo.GetInstanceConnectionDetails(brokerinfo.InstanceInfo{GUID: "instance-id", Type: "postgres"})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(details.MasterUsername).To(Equal("secret-monitoring"))
+				Expect(details.MasterPassword).To(Equal("secret-password"))
+			})
+
+			It("uses the configured username if the secret is only the password", func() {
+				details, err := brokerInfo.GetInstanceConnectionDetails(brokerinfo.InstanceInfo{GUID: "other-instance-id", Type: "postgres"})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(details.MasterUsername).To(Equal("monitoring"))
+				Expect(details.MasterPassword).To(Equal("plain-password"))
+			})
+
+			It("fails if the secret cannot be read", func() {
+				_, err := brokerInfo.GetInstanceConnectionDetails(brokerinfo.InstanceInfo{GUID: "unknown-instance-id", Type: "postgres"})
+				Expect(err).To(MatchError(ContainSubstring("not found")))
+			})
+		})
 	})
 
 })
@@ -0,0 +1,74 @@
+package brokerinfo
+
+import (
+	"fmt"
+
+	"code.cloudfoundry.org/lager/v3"
+
+	"github.com/alphagov/paas-rds-metric-collector/pkg/config"
+)
+
+// StaticBrokerInfo lists the instances from the config rather than from the
+// RDS API, so metrics can be collected from databases outside RDS, such as
+// local databases
+type StaticBrokerInfo struct {
+	instances []config.StaticInstanceConfig
+	logger    lager.Logger
+}
+
+// NewStaticBrokerInfo ...
+func NewStaticBrokerInfo(
+	brokerInfoConfig config.StaticBrokerInfoConfig,
+	logger lager.Logger,
+) *StaticBrokerInfo {
+	return &StaticBrokerInfo{
+		instances: brokerInfoConfig.Instances,
+		logger:    logger,
+	}
+}
+
+func (s *StaticBrokerInfo) ListInstances() ([]InstanceInfo, error) {
+	serviceInstances := []InstanceInfo{}
+	for _, instance := range s.instances {
+		serviceInstances = append(serviceInstances, InstanceInfo{
+			GUID: instance.GUID,
+			Type: instance.Engine,
+			Tags: instance.Tags,
+		})
+	}
+	return serviceInstances, nil
+}
+
+func (s *StaticBrokerInfo) GetInstanceConnectionDetails(instanceInfo InstanceInfo) (InstanceConnectionDetails, error) {
+	instance, ok := s.findInstance(instanceInfo.GUID)
+	if !ok {
+		err := fmt.Errorf("unknown instance: %s", instanceInfo.GUID)
+		s.logger.Error("obtaining instances details", err, lager.Data{"instanceInfo": instanceInfo})
+		return InstanceConnectionDetails{}, err
+	}
+	if instanceInfo.Type != instance.Engine {
+		return InstanceConnectionDetails{}, fmt.Errorf("invalid instance type: %s", instanceInfo.Type)
+	}
+
+	return InstanceConnectionDetails{
+		DBAddress:      instance.Address,
+		DBPort:         instance.Port,
+		DBName:         instance.DBName,
+		MasterUsername: instance.Username,
+		MasterPassword: instance.Password,
+	}, nil
+}
+
+// GetInstanceName returns the GUID, as the instances have no other name
+func (s *StaticBrokerInfo) GetInstanceName(instanceInfo InstanceInfo) string {
+	return instanceInfo.GUID
+}
+
+func (s *StaticBrokerInfo) findInstance(guid string) (config.StaticInstanceConfig, bool) {
+	for _, instance := range s.instances {
+		if instance.GUID == guid {
+			return instance, true
+		}
+	}
+	return config.StaticInstanceConfig{}, false
+}
@@ -0,0 +1,82 @@
+package brokerinfo_test
+
+import (
+	"github.com/alphagov/paas-rds-metric-collector/pkg/brokerinfo"
+	"github.com/alphagov/paas-rds-metric-collector/pkg/config"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("StaticBrokerInfo", func() {
+	var (
+		brokerInfo *brokerinfo.StaticBrokerInfo
+	)
+
+	BeforeEach(func() {
+		brokerInfo = brokerinfo.NewStaticBrokerInfo(
+			config.StaticBrokerInfoConfig{
+				Instances: []config.StaticInstanceConfig{
+					{
+						GUID:     "instance-id-1",
+						Engine:   "postgres",
+						Address:  "localhost",
+						Port:     5432,
+						DBName:   "postgres",
+						Username: "postgres",
+						Password: "123abc",
+						Tags:     map[string]string{"Plan ID": "plan-id-1"},
+					},
+					{
+						GUID:     "instance-id-2",
+						Engine:   "mysql",
+						Address:  "localhost",
+						Port:     3306,
+						DBName:   "mysql",
+						Username: "root",
+					},
+				},
+			},
+			logger,
+		)
+	})
+
+	Context("ListInstances()", func() {
+		It("returns the instances of the config", func() {
+			instances, err := brokerInfo.ListInstances()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(instances).To(Equal([]brokerinfo.InstanceInfo{
+				{GUID: "instance-id-1", Type: "postgres", Tags: map[string]string{"Plan ID": "plan-id-1"}},
+				{GUID: "instance-id-2", Type: "mysql"},
+			}))
+		})
+	})
+
+	Context("GetInstanceConnectionDetails()", func() {
+		It("returns the details of the instance from the config", func() {
+			details, err := brokerInfo.GetInstanceConnectionDetails(brokerinfo.InstanceInfo{GUID: "instance-id-1", Type: "postgres"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(details).To(Equal(brokerinfo.InstanceConnectionDetails{
+				DBAddress:      "localhost",
+				DBPort:         5432,
+				DBName:         "postgres",
+				MasterUsername: "postgres",
+				MasterPassword: "123abc",
+			}))
+		})
+		It("fails if the instance is unknown", func() {
+			_, err := brokerInfo.GetInstanceConnectionDetails(brokerinfo.InstanceInfo{GUID: "instance-id-3", Type: "postgres"})
+			Expect(err).To(MatchError("unknown instance: instance-id-3"))
+		})
+		It("fails if the type is not the engine of the instance", func() {
+			_, err := brokerInfo.GetInstanceConnectionDetails(brokerinfo.InstanceInfo{GUID: "instance-id-2", Type: "postgres"})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("GetInstanceName()", func() {
+		It("returns the GUID of the instance", func() {
+			Expect(brokerInfo.GetInstanceName(brokerinfo.InstanceInfo{GUID: "instance-id-1", Type: "postgres"})).To(Equal("instance-id-1"))
+		})
+	})
+})
@@ -4,6 +4,7 @@ import (
 	"context"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"code.cloudfoundry.org/lager/v3"
@@ -31,7 +32,7 @@ var metricNamesToLabels = map[string]string{
 // NewCloudWatchCollectorDriver ...
 func NewCloudWatchCollectorDriver(intervalSeconds int, session client.ConfigProvider, brokerInfo brokerinfo.BrokerInfo, logger lager.Logger) MetricsCollectorDriver {
 	return &CloudWatchCollectorDriver{
-		collectInterval: intervalSeconds,
+		collectInterval: int64(intervalSeconds),
 		session:         session,
 		brokerInfo:      brokerInfo,
 		logger:          logger,
@@ -40,7 +41,7 @@ func NewCloudWatchCollectorDriver(intervalSeconds int, session client.ConfigProv
 
 // CloudWatchCollectorDriver ...
 type CloudWatchCollectorDriver struct {
-	collectInterval int
+	collectInterval int64
 	session         client.ConfigProvider
 	brokerInfo      brokerinfo.BrokerInfo
 	logger          lager.Logger
@@ -65,7 +66,13 @@ func (cw *CloudWatchCollectorDriver) SupportedTypes() []string {
 }
 
 func (cw *CloudWatchCollectorDriver) GetCollectInterval() int {
-	return cw.collectInterval
+	return int(atomic.LoadInt64(&cw.collectInterval))
+}
+
+// SetCollectInterval changes the interval returned by GetCollectInterval, so
+// it can be changed while the collectors run
+func (cw *CloudWatchCollectorDriver) SetCollectInterval(intervalSeconds int) {
+	atomic.StoreInt64(&cw.collectInterval, int64(intervalSeconds))
 }
 
 // CloudWatchCollector ...
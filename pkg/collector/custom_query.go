@@ -0,0 +1,92 @@
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/alphagov/paas-rds-metric-collector/pkg/config"
+	"github.com/alphagov/paas-rds-metric-collector/pkg/metrics"
+	"github.com/alphagov/paas-rds-metric-collector/pkg/utils"
+)
+
+// customMetricQuery is an operator defined query from the config. It wraps a
+// columnMetricQuery or rowMetricQuery, keeps only the configured tag columns
+// and only runs on the configured server versions.
+type customMetricQuery struct {
+	Name       string
+	Query      metricQuery
+	TagColumns []string
+	serverVersionRange
+}
+
+// newCustomMetricQueries returns the queries from the config for the engine.
+// The config must have been validated.
+func newCustomMetricQueries(engine string, customQueries []config.CustomQueryConfig) []metricQuery {
+	queries := []metricQuery{}
+	for _, c := range customQueries {
+		if c.Engine != engine {
+			continue
+		}
+
+		metricsMeta := []metricQueryMeta{}
+		for _, m := range c.Metrics {
+			kind := metrics.Gauge
+			if m.Kind == metrics.Counter.String() {
+				kind = metrics.Counter
+			}
+			metricsMeta = append(metricsMeta, metricQueryMeta{Key: m.Key, Unit: m.Unit, Kind: kind})
+		}
+
+		q := &customMetricQuery{
+			Name:       c.Name,
+			TagColumns: c.TagColumns,
+		}
+		if c.Type == "row" {
+			q.Query = &rowMetricQuery{Query: c.Query, Metrics: metricsMeta}
+		} else {
+			q.Query = &columnMetricQuery{Query: c.Query, Metrics: metricsMeta}
+		}
+		q.MinServerVersion = parseOptionalVersion(c.MinServerVersion)
+		q.MaxServerVersion = parseOptionalVersion(c.MaxServerVersion)
+
+		queries = append(queries, q)
+	}
+	return queries
+}
+
+func (q *customMetricQuery) name() string {
+	return q.Name
+}
+
+func (q *customMetricQuery) getMetrics(ctx context.Context, db *sql.DB) ([]metrics.Metric, error) {
+	queryMetrics, err := q.Query.getMetrics(ctx, db)
+	if err != nil {
+		return nil, fmt.Errorf("custom query '%s': %s", q.Name, err)
+	}
+	if len(q.TagColumns) == 0 {
+		return queryMetrics, nil
+	}
+
+	for i, m := range queryMetrics {
+		tags := map[string]string{"source": m.Tags["source"]}
+		for _, c := range q.TagColumns {
+			if v, ok := m.Tags[c]; ok {
+				tags[c] = v
+			}
+		}
+		queryMetrics[i].Tags = tags
+	}
+	return queryMetrics, nil
+}
+
+func parseOptionalVersion(s string) *utils.Version {
+	if s == "" {
+		return nil
+	}
+	v, err := utils.ParseVersion(s)
+	if err != nil {
+		return nil
+	}
+	return &v
+}
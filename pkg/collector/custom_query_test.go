@@ -0,0 +1,150 @@
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-rds-metric-collector/pkg/config"
+	"github.com/alphagov/paas-rds-metric-collector/pkg/metrics"
+	"github.com/alphagov/paas-rds-metric-collector/pkg/utils"
+)
+
+type stubMetricQuery struct {
+	metrics []metrics.Metric
+	err     error
+	serverVersionRange
+}
+
+func (q *stubMetricQuery) getMetrics(ctx context.Context, db *sql.DB) ([]metrics.Metric, error) {
+	return q.metrics, q.err
+}
+
+func (q *stubMetricQuery) name() string {
+	return "stub"
+}
+
+var _ = Describe("customMetricQuery", func() {
+	var customQueries []config.CustomQueryConfig
+
+	BeforeEach(func() {
+		customQueries = []config.CustomQueryConfig{
+			{
+				Name:    "pg_column",
+				Engine:  "postgres",
+				Type:    "column",
+				Query:   "SELECT 1 AS foo",
+				Metrics: []config.CustomQueryMetricConfig{{Key: "foo", Unit: "thing"}},
+			},
+			{
+				Name:   "pg_row",
+				Engine: "postgres",
+				Type:   "row",
+				Query:  "SELECT 'bar', 1",
+				Metrics: []config.CustomQueryMetricConfig{
+					{Key: "bar", Unit: "thing", Kind: "counter"},
+				},
+				MinServerVersion: "12.4",
+			},
+			{
+				Name:    "mysql_column",
+				Engine:  "mysql",
+				Type:    "column",
+				Query:   "SELECT 1 AS baz",
+				Metrics: []config.CustomQueryMetricConfig{{Key: "baz", Unit: "thing"}},
+			},
+		}
+	})
+
+	It("builds the queries of the engine from the config", func() {
+		queries := newCustomMetricQueries("postgres", customQueries)
+		Expect(queries).To(Equal([]metricQuery{
+			&customMetricQuery{
+				Name: "pg_column",
+				Query: &columnMetricQuery{
+					Query:   "SELECT 1 AS foo",
+					Metrics: []metricQueryMeta{{Key: "foo", Unit: "thing", Kind: metrics.Gauge}},
+				},
+			},
+			&customMetricQuery{
+				Name: "pg_row",
+				Query: &rowMetricQuery{
+					Query:   "SELECT 'bar', 1",
+					Metrics: []metricQueryMeta{{Key: "bar", Unit: "thing", Kind: metrics.Counter}},
+				},
+				serverVersionRange: serverVersionRange{MinServerVersion: &utils.Version{12, 4, 0}},
+			},
+		}))
+	})
+
+	It("only runs on servers newer than the minimum version", func() {
+		q := newCustomMetricQueries("postgres", customQueries)[1].(*customMetricQuery)
+
+		Expect(q.supportsServerVersion(utils.Version{11, 9, 0})).To(BeFalse())
+		Expect(q.supportsServerVersion(utils.Version{12, 4, 0})).To(BeTrue())
+		Expect(q.supportsServerVersion(utils.Version{13, 0, 0})).To(BeTrue())
+	})
+
+	It("keeps only the configured tag columns", func() {
+		tags := map[string]string{"source": "sql", "datname": "foo", "usename": "bar"}
+		q := &customMetricQuery{
+			Name: "filtered",
+			Query: &stubMetricQuery{metrics: []metrics.Metric{
+				{Key: "a", Value: 1, Tags: tags},
+				{Key: "b", Value: 2, Tags: tags},
+			}},
+			TagColumns: []string{"datname"},
+		}
+
+		collected, err := q.getMetrics(context.Background(), nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(collected).To(HaveLen(2))
+		for _, m := range collected {
+			Expect(m.Tags).To(Equal(map[string]string{"source": "sql", "datname": "foo"}))
+		}
+	})
+
+	It("includes the query name in the errors", func() {
+		q := &customMetricQuery{
+			Name:  "broken",
+			Query: &stubMetricQuery{err: fmt.Errorf("syntax error")},
+		}
+
+		_, err := q.getMetrics(context.Background(), nil)
+		Expect(err).To(MatchError("custom query 'broken': syntax error"))
+	})
+})
+
+var _ = Describe("builtInMetricKeys", func() {
+	It("lists the metrics of the built-in queries, the rates of their counters and the SQL collection metrics", func() {
+		Expect(builtInMetricKeys(postgresMetricQueries)).To(ContainElements(
+			"connections", "commits", "commits_per_second", "query_error", "auth_failure",
+		))
+		Expect(builtInMetricKeys(mysqlMetricQueries)).To(ContainElements(
+			"threads_created", "seconds_behind_master",
+		))
+	})
+
+	It("makes the config reject the custom queries that use them", func() {
+		cfg, err := config.LoadConfig("../../fixtures/collector_config.json")
+		Expect(err).NotTo(HaveOccurred())
+
+		cfg.CustomQueries = []config.CustomQueryConfig{{
+			Name:    "my_connections",
+			Engine:  "postgres",
+			Type:    "column",
+			Query:   "SELECT count(*) AS connections FROM pg_stat_activity",
+			Metrics: []config.CustomQueryMetricConfig{{Key: "connections", Unit: "conn"}},
+		}}
+		Expect(cfg.Validate()).To(MatchError(ContainSubstring(
+			"metric key 'connections' is already used by a built-in postgres metric",
+		)))
+
+		cfg.CustomQueries[0].Metrics[0].Key = "all_connections"
+		cfg.CustomQueries[0].Query = "SELECT count(*) AS all_connections FROM pg_stat_activity"
+		Expect(cfg.Validate()).To(Succeed())
+	})
+})
@@ -3,15 +3,72 @@ package collector
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
 	"regexp"
+	"sync"
+
+	"github.com/lib/pq"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	"github.com/alphagov/paas-rds-metric-collector/pkg/brokerinfo"
 	"github.com/alphagov/paas-rds-metric-collector/pkg/metrics"
 )
 
+// fakeAuthDriver is a SQL driver that only accepts the connections whose
+// data source name is its current password, to test password rotations
+// without a database
+type fakeAuthDriver struct {
+	mutex    sync.Mutex
+	password string
+}
+
+var fakeAuth = &fakeAuthDriver{}
+
+func init() {
+	sql.Register("fake-auth", fakeAuth)
+}
+
+func (d *fakeAuthDriver) setPassword(password string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.password = password
+}
+
+func (d *fakeAuthDriver) Open(name string) (driver.Conn, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if name != d.password {
+		return nil, &pq.Error{Code: "28P01", Message: "password authentication failed"}
+	}
+	return fakeAuthConn{}, nil
+}
+
+type fakeAuthConn struct{}
+
+func (fakeAuthConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (fakeAuthConn) Close() error {
+	return nil
+}
+
+func (fakeAuthConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("not supported")
+}
+
+// passwordConnectionStringBuilder uses the password as connection string,
+// for the fakeAuthDriver
+type passwordConnectionStringBuilder struct{}
+
+func (passwordConnectionStringBuilder) ConnectionString(details brokerinfo.InstanceConnectionDetails) string {
+	return details.MasterPassword
+}
+
 // openMultipleDBConns opens as many connections as specified by
 // count using the given driver and url.
 func openMultipleDBConns(ctx context.Context, count int, driver, url string) (err error, execQuery func(string)) {
@@ -0,0 +1,95 @@
+package collector
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/rds/rdsutils"
+
+	"github.com/alphagov/paas-rds-metric-collector/pkg/brokerinfo"
+)
+
+// authTokenRefreshAge is the age after which an IAM auth token is built
+// again. RDS accepts the tokens for 15 minutes.
+const authTokenRefreshAge = 10 * time.Minute
+
+// AuthTokenBuilder builds an RDS IAM auth token for the user of the database
+// at the endpoint, given as host:port
+type AuthTokenBuilder func(endpoint string, dbUser string) (string, error)
+
+// NewRDSAuthTokenBuilder builds the tokens by signing them with the AWS
+// credentials
+func NewRDSAuthTokenBuilder(region string, creds *credentials.Credentials) AuthTokenBuilder {
+	return func(endpoint string, dbUser string) (string, error) {
+		return rdsutils.BuildAuthToken(endpoint, region, dbUser, creds)
+	}
+}
+
+// authTokenSource returns the IAM auth token of an instance, and builds a
+// new one when the token gets close to its expiry
+type authTokenSource struct {
+	buildToken AuthTokenBuilder
+	endpoint   string
+	dbUser     string
+	clock      clock.Clock
+
+	mutex   sync.Mutex
+	token   string
+	builtAt time.Time
+}
+
+func newAuthTokenSource(
+	buildToken AuthTokenBuilder,
+	details brokerinfo.InstanceConnectionDetails,
+	clock clock.Clock,
+) *authTokenSource {
+	return &authTokenSource{
+		buildToken: buildToken,
+		endpoint:   fmt.Sprintf("%s:%d", details.DBAddress, details.DBPort),
+		dbUser:     details.MasterUsername,
+		clock:      clock,
+	}
+}
+
+func (s *authTokenSource) get() (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.clock.Now()
+	if s.token != "" && now.Sub(s.builtAt) < authTokenRefreshAge {
+		return s.token, nil
+	}
+	token, err := s.buildToken(s.endpoint, s.dbUser)
+	if err != nil {
+		return "", fmt.Errorf("building IAM auth token: %s", err)
+	}
+	s.token = token
+	s.builtAt = now
+	return token, nil
+}
+
+// connectionStringConnector opens every connection with a new connection
+// string, so that the connections opened after the first ones get a fresh
+// IAM auth token as password. The timeouts of the connection string still
+// apply to every connection.
+type connectionStringConnector struct {
+	driver           driver.Driver
+	connectionString func() (string, error)
+}
+
+func (c *connectionStringConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	connectionString, err := c.connectionString()
+	if err != nil {
+		return nil, err
+	}
+	return c.driver.Open(connectionString)
+}
+
+func (c *connectionStringConnector) Driver() driver.Driver {
+	return c.driver
+}
@@ -0,0 +1,98 @@
+package collector
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-rds-metric-collector/pkg/brokerinfo"
+)
+
+// stubAuthTokenBuilder builds numbered tokens and records its calls
+type stubAuthTokenBuilder struct {
+	calls     int
+	endpoints []string
+	dbUsers   []string
+	err       error
+}
+
+func (b *stubAuthTokenBuilder) build(endpoint string, dbUser string) (string, error) {
+	if b.err != nil {
+		return "", b.err
+	}
+	b.calls++
+	b.endpoints = append(b.endpoints, endpoint)
+	b.dbUsers = append(b.dbUsers, dbUser)
+	return fmt.Sprintf("token-%d", b.calls), nil
+}
+
+var _ = Describe("authTokenSource", func() {
+	var (
+		fakeClock    *fakeclock.FakeClock
+		tokenBuilder *stubAuthTokenBuilder
+		tokens       *authTokenSource
+		details      brokerinfo.InstanceConnectionDetails
+	)
+
+	BeforeEach(func() {
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		tokenBuilder = &stubAuthTokenBuilder{}
+		details = brokerinfo.InstanceConnectionDetails{
+			DBAddress:      "endpoint-address.example.com",
+			DBPort:         5432,
+			MasterUsername: "master-username",
+		}
+		tokens = newAuthTokenSource(tokenBuilder.build, details, fakeClock)
+	})
+
+	It("builds a token for the user of the endpoint", func() {
+		token, err := tokens.get()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(token).To(Equal("token-1"))
+		Expect(tokenBuilder.endpoints).To(Equal([]string{"endpoint-address.example.com:5432"}))
+		Expect(tokenBuilder.dbUsers).To(Equal([]string{"master-username"}))
+	})
+
+	It("reuses the token until it gets close to its expiry", func() {
+		tokens.get()
+		fakeClock.Increment(authTokenRefreshAge - time.Second)
+		Expect(tokens.get()).To(Equal("token-1"))
+
+		fakeClock.Increment(time.Second)
+		Expect(tokens.get()).To(Equal("token-2"))
+		Expect(authTokenRefreshAge).To(BeNumerically("<", 15*time.Minute))
+	})
+
+	It("returns the error of the token builder", func() {
+		tokenBuilder.err = fmt.Errorf("no credentials")
+		_, err := tokens.get()
+		Expect(err).To(MatchError("building IAM auth token: no credentials"))
+	})
+
+	Context("connectionStringConnector", func() {
+		It("opens every connection with the current token", func() {
+			connector := &connectionStringConnector{
+				driver:           fakeAuth,
+				connectionString: tokens.get,
+			}
+			db := sql.OpenDB(connector)
+			defer db.Close()
+			db.SetMaxIdleConns(0)
+
+			fakeAuth.setPassword("token-1")
+			Expect(db.PingContext(context.Background())).To(Succeed())
+
+			fakeClock.Increment(authTokenRefreshAge)
+			Expect(db.PingContext(context.Background())).To(HaveOccurred())
+
+			fakeAuth.setPassword("token-2")
+			Expect(db.PingContext(context.Background())).To(Succeed())
+		})
+	})
+})
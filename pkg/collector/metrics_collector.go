@@ -13,9 +13,13 @@ type MetricsCollectorDriver interface {
 	GetName() string
 	SupportedTypes() []string
 	GetCollectInterval() int
+	SetCollectInterval(intervalSeconds int)
 }
 
 // MetricsCollector ...
+//
+// Collect can return some metrics together with an error, such as the metrics
+// that tell why the collection failed. They are emitted like the others.
 type MetricsCollector interface {
 	Collect(ctx context.Context) ([]metrics.Metric, error)
 	Close() error
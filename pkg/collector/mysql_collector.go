@@ -1,16 +1,23 @@
 package collector
 
 import (
+	"errors"
 	"fmt"
 
+	"code.cloudfoundry.org/clock"
 	"code.cloudfoundry.org/lager/v3"
-
-	// Used in the SQL driver.
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 
 	"github.com/alphagov/paas-rds-metric-collector/pkg/brokerinfo"
+	"github.com/alphagov/paas-rds-metric-collector/pkg/config"
+	"github.com/alphagov/paas-rds-metric-collector/pkg/metrics"
+	"github.com/alphagov/paas-rds-metric-collector/pkg/utils"
 )
 
+func init() {
+	config.RegisterBuiltInMetricKeys("mysql", builtInMetricKeys(mysqlMetricQueries))
+}
+
 var mysqlMetricQueries = []metricQuery{
 	&rowMetricQuery{
 		Query: `
@@ -39,22 +46,27 @@ var mysqlMetricQueries = []metricQuery{
 			{
 				Key:  "threads_created",
 				Unit: "conn",
+				Kind: metrics.Counter,
 			},
 			{
 				Key:  "queries",
 				Unit: "conn",
+				Kind: metrics.Counter,
 			},
 			{
 				Key:  "questions",
 				Unit: "conn",
+				Kind: metrics.Counter,
 			},
 			{
 				Key:  "aborted_clients",
 				Unit: "conn",
+				Kind: metrics.Counter,
 			},
 			{
 				Key:  "aborted_connects",
 				Unit: "conn",
+				Kind: metrics.Counter,
 			},
 		},
 	},
@@ -88,10 +100,12 @@ var mysqlMetricQueries = []metricQuery{
 			{
 				Key:  "innodb_row_lock_waits",
 				Unit: "guage",
+				Kind: metrics.Counter,
 			},
 			{
 				Key:  "innodb_row_lock_time",
 				Unit: "ms",
+				Kind: metrics.Counter,
 			},
 			{
 				Key:  "innodb_num_open_files",
@@ -100,6 +114,7 @@ var mysqlMetricQueries = []metricQuery{
 			{
 				Key:  "innodb_log_waits",
 				Unit: "guage",
+				Kind: metrics.Counter,
 			},
 			{
 				Key:  "innodb_buffer_pool_bytes_data",
@@ -120,6 +135,7 @@ var mysqlMetricQueries = []metricQuery{
 			{
 				Key:  "innodb_buffer_pool_pages_flushed",
 				Unit: "pages",
+				Kind: metrics.Counter,
 			},
 			{
 				Key:  "innodb_buffer_pool_pages_free",
@@ -136,30 +152,37 @@ var mysqlMetricQueries = []metricQuery{
 			{
 				Key:  "innodb_buffer_pool_read_ahead",
 				Unit: "pages",
+				Kind: metrics.Counter,
 			},
 			{
 				Key:  "innodb_buffer_pool_read_ahead_evicted",
 				Unit: "pages",
+				Kind: metrics.Counter,
 			},
 			{
 				Key:  "innodb_buffer_pool_read_ahead_rnd",
 				Unit: "guage",
+				Kind: metrics.Counter,
 			},
 			{
 				Key:  "innodb_buffer_pool_read_requests",
 				Unit: "conn",
+				Kind: metrics.Counter,
 			},
 			{
 				Key:  "innodb_buffer_pool_reads",
 				Unit: "guage",
+				Kind: metrics.Counter,
 			},
 			{
 				Key:  "innodb_buffer_pool_wait_free",
 				Unit: "guage",
+				Kind: metrics.Counter,
 			},
 			{
 				Key:  "innodb_buffer_pool_write_requests",
 				Unit: "guage",
+				Kind: metrics.Counter,
 			},
 		},
 	},
@@ -188,16 +211,65 @@ var mysqlMetricQueries = []metricQuery{
 			{
 				Key:  "connection_errors",
 				Unit: "err",
+				Kind: metrics.Counter,
 			},
 		},
 	},
+	// SHOW SLAVE STATUS was renamed, with its columns, in MySQL 8.0.22
+	mysqlReplicaStatusQuery(
+		"SHOW REPLICA STATUS",
+		"Seconds_Behind_Source", "Replica_IO_Running", "Replica_SQL_Running",
+		serverVersionRange{MinServerVersion: &utils.Version{8, 0, 22}},
+	),
+	mysqlReplicaStatusQuery(
+		"SHOW SLAVE STATUS",
+		"Seconds_Behind_Master", "Slave_IO_Running", "Slave_SQL_Running",
+		serverVersionRange{MaxServerVersion: &utils.Version{8, 0, 22}},
+	),
+}
+
+// mysqlReplicaStatusQuery reads the replication metrics from the replica
+// status statement, given with the names of its columns
+func mysqlReplicaStatusQuery(
+	query string,
+	secondsBehindColumn string,
+	ioRunningColumn string,
+	sqlRunningColumn string,
+	versionRange serverVersionRange,
+) metricQuery {
+	return &wideRowMetricQuery{
+		Query: query,
+		Metrics: []wideRowMetricQueryMeta{
+			{
+				metricQueryMeta: metricQueryMeta{Key: "seconds_behind_master", Unit: "s"},
+				Column:          secondsBehindColumn,
+			},
+			{
+				metricQueryMeta: metricQueryMeta{Key: "replica_io_running", Unit: "bool"},
+				Column:          ioRunningColumn,
+				Boolean:         true,
+			},
+			{
+				metricQueryMeta: metricQueryMeta{Key: "replica_sql_running", Unit: "bool"},
+				Column:          sqlRunningColumn,
+				Boolean:         true,
+			},
+			{
+				metricQueryMeta: metricQueryMeta{Key: "relay_log_space", Unit: "byte"},
+				Column:          "Relay_Log_Space",
+			},
+		},
+		TagColumns:         []string{"Channel_Name"},
+		serverVersionRange: versionRange,
+	}
 }
 
 type mysqlConnectionStringBuilder struct {
-	ConnectionTimeout int
-	ReadTimeout       int
-	WriteTimeout      int
-	TLS               string
+	ConnectionTimeout       int
+	ReadTimeout             int
+	WriteTimeout            int
+	TLS                     string
+	AllowCleartextPasswords bool
 }
 
 func (m *mysqlConnectionStringBuilder) ConnectionString(details brokerinfo.InstanceConnectionDetails) string {
@@ -206,7 +278,7 @@ func (m *mysqlConnectionStringBuilder) ConnectionString(details brokerinfo.Insta
 		tls = m.TLS
 	}
 
-	return fmt.Sprintf(
+	connectionString := fmt.Sprintf(
 		"%s:%s@tcp(%s:%d)/%s?tls=%s&timeout=%ds&readTimeout=%ds&writeTimeout=%ds",
 		details.MasterUsername,
 		details.MasterPassword,
@@ -218,6 +290,19 @@ func (m *mysqlConnectionStringBuilder) ConnectionString(details brokerinfo.Insta
 		m.ReadTimeout,
 		m.WriteTimeout,
 	)
+	if m.AllowCleartextPasswords {
+		connectionString += "&allowCleartextPasswords=true"
+	}
+	return connectionString
+}
+
+// mysqlAccessDeniedError is ER_ACCESS_DENIED_ERROR, returned when the
+// password is wrong
+const mysqlAccessDeniedError = 1045
+
+func isMysqlAuthError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlAccessDeniedError
 }
 
 // NewMysqlMetricsCollectorDriver ...
@@ -226,20 +311,37 @@ func NewMysqlMetricsCollectorDriver(
 	intervalSeconds int,
 	timeout int,
 	TLS string,
+	collectorConfig config.MysqlCollectorConfig,
+	customQueries []config.CustomQueryConfig,
+	authTokenBuilder AuthTokenBuilder,
 	logger lager.Logger,
 ) MetricsCollectorDriver {
-	return &sqlMetricsCollectorDriver{
-		collectInterval: intervalSeconds,
+	queries := append([]metricQuery{}, mysqlMetricQueries...)
+	queries = append(queries, newCustomMetricQueries("mysql", customQueries)...)
+
+	driver := &sqlMetricsCollectorDriver{
+		collectInterval: int64(intervalSeconds),
 		logger:          logger,
-		queries:         mysqlMetricQueries,
+		queries:         queries,
 		driver:          "mysql",
 		brokerInfo:      brokerInfo,
+		clock:           clock.NewClock(),
 		name:            "mysql",
+
+		serverVersionQuery: "SELECT VERSION()",
+
 		connectionStringBuilder: &mysqlConnectionStringBuilder{
 			ConnectionTimeout: timeout,
 			ReadTimeout:       timeout,
 			WriteTimeout:      timeout,
 			TLS:               TLS,
+			// The IAM auth tokens are sent in clear text, over TLS
+			AllowCleartextPasswords: collectorConfig.IAMAuth,
 		},
+		isAuthError: isMysqlAuthError,
+	}
+	if collectorConfig.IAMAuth {
+		driver.authTokenBuilder = authTokenBuilder
 	}
+	return driver
 }
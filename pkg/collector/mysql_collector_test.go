@@ -17,6 +17,7 @@ import (
 
 	"github.com/alphagov/paas-rds-metric-collector/pkg/brokerinfo"
 	"github.com/alphagov/paas-rds-metric-collector/pkg/brokerinfo/fakebrokerinfo"
+	"github.com/alphagov/paas-rds-metric-collector/pkg/config"
 	"github.com/alphagov/paas-rds-metric-collector/pkg/metrics"
 	"github.com/alphagov/paas-rds-metric-collector/pkg/utils"
 )
@@ -80,6 +81,20 @@ var _ = Describe("NewMysqlMetricsCollectorDriver", func() {
 			5,
 			10,
 			mysqlConfig.TLSConfig,
+			config.MysqlCollectorConfig{},
+			[]config.CustomQueryConfig{
+				{
+					Name:   "custom_status",
+					Engine: "mysql",
+					Type:   "row",
+					Query:  "SHOW GLOBAL STATUS WHERE variable_name IN ('Uptime', 'Questions')",
+					Metrics: []config.CustomQueryMetricConfig{
+						{Key: "uptime", Unit: "s"},
+						{Key: "questions", Unit: "query", Kind: "counter"},
+					},
+				},
+			},
+			nil,
 			logger,
 		)
 
@@ -110,11 +125,30 @@ var _ = Describe("NewMysqlMetricsCollectorDriver", func() {
 		Expect(metricsCollectorDriver.GetName()).To(Equal("mysql"))
 	})
 
+	It("runs the custom queries for mysql", func() {
+		metric := getMetricByKey(collectedMetrics, "uptime")
+		Expect(metric).ToNot(BeNil())
+		Expect(metric.Unit).To(Equal("s"))
+		Expect(metric.Kind).To(Equal(metrics.Gauge))
+
+		metric = getMetricByKey(collectedMetrics, "questions")
+		Expect(metric).ToNot(BeNil())
+		Expect(metric.Kind).To(Equal(metrics.Counter))
+	})
+
 	It("can collect the number of connection_errors", func() {
 		metric := getMetricByKey(collectedMetrics, "connection_errors")
 		Expect(metric).ToNot(BeNil())
 		Expect(metric.Value).To(BeNumerically(">=", 0))
 		Expect(metric.Unit).To(Equal("err"))
+		Expect(metric.Kind).To(Equal(metrics.Counter))
+	})
+
+	It("does not collect replication metrics if the server is not a replica", func() {
+		Expect(getMetricByKey(collectedMetrics, "seconds_behind_master")).To(BeNil())
+		Expect(getMetricByKey(collectedMetrics, "replica_io_running")).To(BeNil())
+		Expect(getMetricByKey(collectedMetrics, "replica_sql_running")).To(BeNil())
+		Expect(getMetricByKey(collectedMetrics, "relay_log_space")).To(BeNil())
 	})
 
 	It("can collect connection-related metrics", func() {
@@ -175,6 +209,7 @@ var _ = Describe("NewMysqlMetricsCollectorDriver", func() {
 		Expect(metric).ToNot(BeNil())
 		Expect(metric.Value).To(BeNumerically(">=", 1))
 		Expect(metric.Unit).To(Equal("conn"))
+		Expect(metric.Kind).To(Equal(metrics.Gauge))
 
 		initialConnections := metric.Value
 
@@ -245,6 +280,31 @@ var _ = Describe("mysqlConnectionStringBuilder.ConnectionString()", func() {
 		Expect(connectionString).To(Equal("master-username:9Fs6CWnuwf0BAY3rDFAels3OXANSo0-M@tcp(endpoint-address.example.com:5432)/dbprefix-db?tls=skip-verify&timeout=10s&readTimeout=11s&writeTimeout=12s"))
 	})
 
+	It("allows clear text passwords for the IAM auth tokens", func() {
+		details := brokerinfo.InstanceConnectionDetails{
+			DBAddress:      "endpoint-address.example.com",
+			DBPort:         3306,
+			DBName:         "dbprefix-db",
+			MasterUsername: "master-username",
+			MasterPassword: "endpoint-address.example.com:3306/?Action=connect&DBUser=master-username&X-Amz-Signature=abc",
+		}
+		builder := mysqlConnectionStringBuilder{
+			ConnectionTimeout:       10,
+			ReadTimeout:             11,
+			WriteTimeout:            12,
+			TLS:                     "true",
+			AllowCleartextPasswords: true,
+		}
+		connectionString := builder.ConnectionString(details)
+		Expect(connectionString).To(HaveSuffix("&allowCleartextPasswords=true"))
+
+		mysqlConfig, err := mysql.ParseDSN(connectionString)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(mysqlConfig.Passwd).To(Equal(details.MasterPassword))
+		Expect(mysqlConfig.DBName).To(Equal("dbprefix-db"))
+		Expect(mysqlConfig.AllowCleartextPasswords).To(BeTrue())
+	})
+
 	It("should timeout mysql connection", func() {
 		details := brokerinfo.InstanceConnectionDetails{
 			DBAddress:      "1.2.3.4",
@@ -270,3 +330,34 @@ var _ = Describe("mysqlConnectionStringBuilder.ConnectionString()", func() {
 		Expect(endTime).To(BeTemporally("~", startTime, 2*time.Second))
 	})
 })
+
+var _ = Describe("isMysqlAuthError()", func() {
+	It("detects the errors for rejected credentials", func() {
+		Expect(isMysqlAuthError(&mysql.MySQLError{Number: 1045})).To(BeTrue())
+		Expect(isMysqlAuthError(fmt.Errorf("pinging: %w", &mysql.MySQLError{Number: 1045}))).To(BeTrue())
+	})
+
+	It("ignores the other errors", func() {
+		Expect(isMysqlAuthError(&mysql.MySQLError{Number: 1040})).To(BeFalse())
+		Expect(isMysqlAuthError(fmt.Errorf("connection refused"))).To(BeFalse())
+	})
+})
+
+var _ = Describe("mysql replica status queries", func() {
+	replicaStatusQueriesFor := func(v utils.Version) []string {
+		queries := []string{}
+		for _, q := range mysqlMetricQueries {
+			if wq, ok := q.(*wideRowMetricQuery); ok && wq.supportsServerVersion(v) {
+				queries = append(queries, wq.Query)
+			}
+		}
+		return queries
+	}
+
+	It("runs the statement of the server version", func() {
+		Expect(replicaStatusQueriesFor(utils.Version{5, 7, 44})).To(Equal([]string{"SHOW SLAVE STATUS"}))
+		Expect(replicaStatusQueriesFor(utils.Version{8, 0, 21})).To(Equal([]string{"SHOW SLAVE STATUS"}))
+		Expect(replicaStatusQueriesFor(utils.Version{8, 0, 22})).To(Equal([]string{"SHOW REPLICA STATUS"}))
+		Expect(replicaStatusQueriesFor(utils.Version{8, 4, 0})).To(Equal([]string{"SHOW REPLICA STATUS"}))
+	})
+})
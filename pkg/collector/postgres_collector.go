@@ -1,16 +1,30 @@
 package collector
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
 
+	"code.cloudfoundry.org/clock"
 	"code.cloudfoundry.org/lager/v3"
+	"github.com/lib/pq"
 
 	// Used in the SQL driver.
 	_ "github.com/Kount/pq-timeouts"
 
 	"github.com/alphagov/paas-rds-metric-collector/pkg/brokerinfo"
+	"github.com/alphagov/paas-rds-metric-collector/pkg/config"
+	"github.com/alphagov/paas-rds-metric-collector/pkg/metrics"
+	"github.com/alphagov/paas-rds-metric-collector/pkg/utils"
 )
 
+func init() {
+	queries := append([]metricQuery{}, postgresMetricQueries...)
+	queries = append(queries, postgresTableMetricsQuery(1))
+	queries = append(queries, postgresStatementMetricQueries...)
+	config.RegisterBuiltInMetricKeys("postgres", builtInMetricKeys(queries))
+}
+
 var postgresMetricQueries = []metricQuery{
 	&columnMetricQuery{
 		Query: `
@@ -73,34 +87,42 @@ var postgresMetricQueries = []metricQuery{
 			{
 				Key:  "deadlocks",
 				Unit: "lock",
+				Kind: metrics.Counter,
 			},
 			{
 				Key:  "commits",
 				Unit: "tx",
+				Kind: metrics.Counter,
 			},
 			{
 				Key:  "rollbacks",
 				Unit: "tx",
+				Kind: metrics.Counter,
 			},
 			{
 				Key:  "blocks_read",
 				Unit: "block",
+				Kind: metrics.Counter,
 			},
 			{
 				Key:  "blocks_hit",
 				Unit: "block",
+				Kind: metrics.Counter,
 			},
 			{
 				Key:  "read_time",
 				Unit: "ms",
+				Kind: metrics.Counter,
 			},
 			{
 				Key:  "write_time",
 				Unit: "ms",
+				Kind: metrics.Counter,
 			},
 			{
 				Key:  "temp_bytes",
 				Unit: "byte",
+				Kind: metrics.Counter,
 			},
 		},
 	},
@@ -116,10 +138,12 @@ var postgresMetricQueries = []metricQuery{
 			{
 				Key:  "seq_scan",
 				Unit: "scan",
+				Kind: metrics.Counter,
 			},
 			{
 				Key:  "idx_scan",
 				Unit: "scan",
+				Kind: metrics.Counter,
 			},
 		},
 	},
@@ -173,6 +197,321 @@ var postgresMetricQueries = []metricQuery{
 			},
 		},
 	},
+	&columnMetricQuery{
+		Query: `
+			SELECT
+				COALESCE(EXTRACT(epoch FROM write_lag)::FLOAT, 0) as replication_write_lag,
+				COALESCE(EXTRACT(epoch FROM flush_lag)::FLOAT, 0) as replication_flush_lag,
+				COALESCE(EXTRACT(epoch FROM replay_lag)::FLOAT, 0) as replication_replay_lag,
+				COALESCE(application_name, '') as application_name,
+				COALESCE(host(client_addr), '') as client_addr
+			FROM pg_stat_replication
+		`,
+		Metrics: []metricQueryMeta{
+			{
+				Key:  "replication_write_lag",
+				Unit: "s",
+			},
+			{
+				Key:  "replication_flush_lag",
+				Unit: "s",
+			},
+			{
+				Key:  "replication_replay_lag",
+				Unit: "s",
+			},
+		},
+		// The lag columns were added in PostgreSQL 10. The application
+		// name is often the same for every replica, e.g. walreceiver, so
+		// the replicas are told apart by their address.
+		serverVersionRange: serverVersionRange{MinServerVersion: &utils.Version{10, 0, 0}},
+	},
+	&columnMetricQuery{
+		Query: `
+			SELECT
+				pg_wal_lsn_diff(
+					CASE WHEN pg_is_in_recovery()
+						THEN pg_last_wal_replay_lsn()
+						ELSE pg_current_wal_lsn()
+					END,
+					'0/0'
+				)::FLOAT as wal_bytes
+		`,
+		Metrics: []metricQueryMeta{
+			{
+				Key:  "wal_bytes",
+				Unit: "byte",
+				Kind: metrics.Counter,
+			},
+		},
+		serverVersionRange: serverVersionRange{MinServerVersion: &utils.Version{10, 0, 0}},
+	},
+	// The WAL functions were called xlog before PostgreSQL 10
+	&columnMetricQuery{
+		Query: `
+			SELECT
+				pg_xlog_location_diff(
+					CASE WHEN pg_is_in_recovery()
+						THEN pg_last_xlog_replay_location()
+						ELSE pg_current_xlog_location()
+					END,
+					'0/0'
+				)::FLOAT as wal_bytes
+		`,
+		Metrics: []metricQueryMeta{
+			{
+				Key:  "wal_bytes",
+				Unit: "byte",
+				Kind: metrics.Counter,
+			},
+		},
+		serverVersionRange: serverVersionRange{MaxServerVersion: &utils.Version{10, 0, 0}},
+	},
+	&columnMetricQuery{
+		Query: `
+			SELECT
+				pg_wal_lsn_diff(
+					CASE WHEN pg_is_in_recovery()
+						THEN pg_last_wal_replay_lsn()
+						ELSE pg_current_wal_lsn()
+					END,
+					restart_lsn
+				)::FLOAT as replication_slot_retained_wal,
+				CASE WHEN active THEN 1 ELSE 0 END as replication_slot_active,
+				slot_name as slot_name
+			FROM pg_replication_slots
+			WHERE restart_lsn IS NOT NULL
+		`,
+		Metrics: []metricQueryMeta{
+			{
+				Key:  "replication_slot_retained_wal",
+				Unit: "byte",
+			},
+			{
+				Key:  "replication_slot_active",
+				Unit: "bool",
+			},
+		},
+		serverVersionRange: serverVersionRange{MinServerVersion: &utils.Version{10, 0, 0}},
+	},
+	&columnMetricQuery{
+		Query: `
+			SELECT
+				pg_xlog_location_diff(
+					CASE WHEN pg_is_in_recovery()
+						THEN pg_last_xlog_replay_location()
+						ELSE pg_current_xlog_location()
+					END,
+					restart_lsn
+				)::FLOAT as replication_slot_retained_wal,
+				CASE WHEN active THEN 1 ELSE 0 END as replication_slot_active,
+				slot_name as slot_name
+			FROM pg_replication_slots
+			WHERE restart_lsn IS NOT NULL
+		`,
+		Metrics: []metricQueryMeta{
+			{
+				Key:  "replication_slot_retained_wal",
+				Unit: "byte",
+			},
+			{
+				Key:  "replication_slot_active",
+				Unit: "bool",
+			},
+		},
+		serverVersionRange: serverVersionRange{MaxServerVersion: &utils.Version{10, 0, 0}},
+	},
+	&columnMetricQuery{
+		Query: `
+			SELECT
+				age(datfrozenxid)::FLOAT as database_xid_age,
+				datname as dbname
+			FROM pg_database
+		`,
+		Metrics: []metricQueryMeta{
+			{
+				Key:  "database_xid_age",
+				Unit: "xid",
+			},
+		},
+	},
+	&columnMetricQuery{
+		Query: `
+			SELECT
+				COALESCE(MAX(age(relfrozenxid)), 0)::FLOAT as max_table_xid_age,
+				current_database() as dbname
+			FROM pg_class
+			WHERE relkind IN ('r', 'm', 't')
+		`,
+		Metrics: []metricQueryMeta{
+			{
+				Key:  "max_table_xid_age",
+				Unit: "xid",
+			},
+		},
+	},
+	&columnMetricQuery{
+		Query: `
+			SELECT
+				MAX(age(datfrozenxid))::FLOAT /
+					current_setting('autovacuum_freeze_max_age')::FLOAT as xid_wraparound_ratio
+			FROM pg_database
+		`,
+		Metrics: []metricQueryMeta{
+			{
+				Key:  "xid_wraparound_ratio",
+				Unit: "ratio",
+			},
+		},
+	},
+	&columnMetricQuery{
+		Query: `
+			SELECT
+				count(*) as autovacuum_workers
+			FROM pg_stat_activity
+			WHERE backend_type = 'autovacuum worker'
+		`,
+		Metrics: []metricQueryMeta{
+			{
+				Key:  "autovacuum_workers",
+				Unit: "worker",
+			},
+		},
+		serverVersionRange: serverVersionRange{MinServerVersion: &utils.Version{10, 0, 0}},
+	},
+	// pg_stat_activity has no backend_type before PostgreSQL 10
+	&columnMetricQuery{
+		Query: `
+			SELECT
+				count(*) as autovacuum_workers
+			FROM pg_stat_activity
+			WHERE query LIKE 'autovacuum: %'
+		`,
+		Metrics: []metricQueryMeta{
+			{
+				Key:  "autovacuum_workers",
+				Unit: "worker",
+			},
+		},
+		serverVersionRange: serverVersionRange{MaxServerVersion: &utils.Version{10, 0, 0}},
+	},
+}
+
+// postgresStatementMetricQueries need the pg_stat_statements extension in
+// the database. Its time columns were renamed in PostgreSQL 13, when planning
+// time started to be tracked separately.
+var postgresStatementMetricQueries = []metricQuery{
+	&columnMetricQuery{
+		Query: `
+			SELECT
+				COALESCE(SUM(calls), 0)::FLOAT as statements_calls,
+				COALESCE(SUM(total_time), 0)::FLOAT as statements_exec_time,
+				COALESCE(SUM(rows), 0)::FLOAT as statements_rows,
+				current_database() as dbname
+			FROM pg_stat_statements s
+			INNER JOIN pg_database d ON d.oid = s.dbid
+			WHERE d.datname = current_database()
+		`,
+		Metrics: postgresStatementMetricsMeta,
+		serverVersionRange: serverVersionRange{
+			MaxServerVersion: &utils.Version{13, 0, 0},
+		},
+	},
+	&columnMetricQuery{
+		Query: `
+			SELECT
+				COALESCE(SUM(calls), 0)::FLOAT as statements_calls,
+				COALESCE(SUM(total_exec_time), 0)::FLOAT as statements_exec_time,
+				COALESCE(SUM(rows), 0)::FLOAT as statements_rows,
+				COALESCE(SUM(total_plan_time), 0)::FLOAT as statements_plan_time,
+				current_database() as dbname
+			FROM pg_stat_statements s
+			INNER JOIN pg_database d ON d.oid = s.dbid
+			WHERE d.datname = current_database()
+		`,
+		Metrics: append(
+			append([]metricQueryMeta{}, postgresStatementMetricsMeta...),
+			metricQueryMeta{Key: "statements_plan_time", Unit: "ms", Kind: metrics.Counter},
+		),
+		serverVersionRange: serverVersionRange{
+			MinServerVersion: &utils.Version{13, 0, 0},
+		},
+	},
+}
+
+var postgresStatementMetricsMeta = []metricQueryMeta{
+	{
+		Key:  "statements_calls",
+		Unit: "call",
+		Kind: metrics.Counter,
+	},
+	{
+		Key:  "statements_exec_time",
+		Unit: "ms",
+		Kind: metrics.Counter,
+	},
+	{
+		Key:  "statements_rows",
+		Unit: "row",
+		Kind: metrics.Counter,
+	},
+}
+
+// postgresTableMetricsQuery returns per-table metrics for, at most, the
+// given number of tables, the most scanned first.
+func postgresTableMetricsQuery(maxTables int) metricQuery {
+	return &columnMetricQuery{
+		Query: fmt.Sprintf(`
+			SELECT
+				COALESCE(t.seq_scan, 0) as table_seq_scan,
+				COALESCE(t.idx_scan, 0) as table_idx_scan,
+				t.n_live_tup as table_live_tuples,
+				t.n_dead_tup as table_dead_tuples,
+				COALESCE(EXTRACT(epoch FROM now() - t.last_autovacuum)::FLOAT, -1) as table_last_autovacuum_age,
+				COALESCE(
+					io.heap_blks_hit::FLOAT / NULLIF(io.heap_blks_hit + io.heap_blks_read, 0),
+					1
+				) as table_heap_hit_ratio,
+				t.schemaname as schema,
+				t.relname as "table",
+				current_database() as dbname
+			FROM pg_stat_user_tables t
+			INNER JOIN pg_statio_user_tables io ON io.relid = t.relid
+			ORDER BY
+				COALESCE(t.seq_scan, 0) + COALESCE(t.idx_scan, 0) DESC,
+				t.schemaname,
+				t.relname
+			LIMIT %d
+		`, maxTables),
+		Metrics: []metricQueryMeta{
+			{
+				Key:  "table_seq_scan",
+				Unit: "scan",
+				Kind: metrics.Counter,
+			},
+			{
+				Key:  "table_idx_scan",
+				Unit: "scan",
+				Kind: metrics.Counter,
+			},
+			{
+				Key:  "table_live_tuples",
+				Unit: "tuple",
+			},
+			{
+				Key:  "table_dead_tuples",
+				Unit: "tuple",
+			},
+			{
+				Key:  "table_last_autovacuum_age",
+				Unit: "s",
+			},
+			{
+				Key:  "table_heap_hit_ratio",
+				Unit: "ratio",
+			},
+		},
+	}
 }
 
 type postgresConnectionStringBuilder struct {
@@ -180,6 +519,8 @@ type postgresConnectionStringBuilder struct {
 	ReadTimeout       int
 	WriteTimeout      int
 	SSLMode           string
+	// SSLRootCert is the CA bundle to check the server certificates with
+	SSLRootCert string
 }
 
 func (m *postgresConnectionStringBuilder) ConnectionString(details brokerinfo.InstanceConnectionDetails) string {
@@ -187,10 +528,10 @@ func (m *postgresConnectionStringBuilder) ConnectionString(details brokerinfo.In
 	if m.SSLMode != "" {
 		sslMode = m.SSLMode
 	}
-	return fmt.Sprintf(
-		"postgresql://%s:%s@%s:%d/%s?sslmode=%s&connect_timeout=%d&read_timeout=%d&write_timeout=%d",
-		details.MasterUsername,
-		details.MasterPassword,
+	// The credentials are escaped, as IAM auth tokens are URLs themselves
+	connectionString := fmt.Sprintf(
+		"postgresql://%s@%s:%d/%s?sslmode=%s&connect_timeout=%d&read_timeout=%d&write_timeout=%d",
+		url.UserPassword(details.MasterUsername, details.MasterPassword).String(),
 		details.DBAddress,
 		details.DBPort,
 		details.DBName,
@@ -199,6 +540,27 @@ func (m *postgresConnectionStringBuilder) ConnectionString(details brokerinfo.In
 		m.ReadTimeout*1000,
 		m.WriteTimeout*1000,
 	)
+	if m.SSLRootCert != "" {
+		connectionString += "&sslrootcert=" + url.QueryEscape(m.SSLRootCert)
+	}
+	return connectionString
+}
+
+// postgresAuthErrorCodes are the error codes returned when the credentials
+// are rejected: invalid_authorization_specification and invalid_password
+var postgresAuthErrorCodes = []pq.ErrorCode{"28000", "28P01"}
+
+func isPostgresAuthError(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	for _, code := range postgresAuthErrorCodes {
+		if pqErr.Code == code {
+			return true
+		}
+	}
+	return false
 }
 
 // NewPostgresMetricsCollectorDriver ...
@@ -206,21 +568,48 @@ func NewPostgresMetricsCollectorDriver(
 	brokerInfo brokerinfo.BrokerInfo,
 	intervalSeconds int,
 	timeout int,
-	SSLMode string,
+	caBundleFile string,
+	collectorConfig config.PostgresCollectorConfig,
+	customQueries []config.CustomQueryConfig,
+	authTokenBuilder AuthTokenBuilder,
 	logger lager.Logger,
 ) MetricsCollectorDriver {
-	return &sqlMetricsCollectorDriver{
-		collectInterval: intervalSeconds,
+	queries := append([]metricQuery{}, postgresMetricQueries...)
+	if collectorConfig.TableMetricsMaxTables > 0 {
+		queries = append(queries, postgresTableMetricsQuery(collectorConfig.TableMetricsMaxTables))
+	}
+	if collectorConfig.StatementMetrics {
+		queries = append(queries, postgresStatementMetricQueries...)
+	}
+	queries = append(queries, newCustomMetricQueries("postgres", customQueries)...)
+
+	sslRootCert := ""
+	if collectorConfig.VerifiesServerCertificates() {
+		sslRootCert = caBundleFile
+	}
+
+	driver := &sqlMetricsCollectorDriver{
+		collectInterval: int64(intervalSeconds),
 		logger:          logger,
-		queries:         postgresMetricQueries,
+		queries:         queries,
 		driver:          "pq-timeouts",
 		brokerInfo:      brokerInfo,
+		clock:           clock.NewClock(),
 		name:            "postgres",
+
+		serverVersionQuery: "SHOW server_version",
+
 		connectionStringBuilder: &postgresConnectionStringBuilder{
 			ConnectionTimeout: timeout,
 			ReadTimeout:       timeout,
 			WriteTimeout:      timeout,
-			SSLMode:           SSLMode,
+			SSLMode:           collectorConfig.SSLMode,
+			SSLRootCert:       sslRootCert,
 		},
+		isAuthError: isPostgresAuthError,
+	}
+	if collectorConfig.IAMAuth {
+		driver.authTokenBuilder = authTokenBuilder
 	}
+	return driver
 }
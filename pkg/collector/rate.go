@@ -0,0 +1,95 @@
+package collector
+
+import (
+	"sort"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+
+	"github.com/alphagov/paas-rds-metric-collector/pkg/metrics"
+)
+
+const rateMetricSuffix = "_per_second"
+
+// counterSampleMaxAge is how long the last sample of a counter is kept when
+// the counter is not collected, e.g. because its query failed, before it is
+// forgotten
+const counterSampleMaxAge = 15 * time.Minute
+
+type counterSample struct {
+	value float64
+	time  time.Time
+}
+
+// counterRateCalculator remembers the last sample of every counter metric
+// and derives a per-second rate gauge from two consecutive samples.
+//
+// No rate is returned for the first sample of a counter, nor when the counter
+// went backwards, which happens when the database server restarts. In both
+// cases the current sample becomes the baseline for the next collection.
+//
+// The counters missing from a collection keep their last sample, so that a
+// query failing once does not lose the baseline of its counters. The samples
+// older than counterSampleMaxAge are forgotten.
+type counterRateCalculator struct {
+	clock    clock.Clock
+	previous map[string]counterSample
+}
+
+func newCounterRateCalculator(clock clock.Clock) *counterRateCalculator {
+	return &counterRateCalculator{
+		clock:    clock,
+		previous: map[string]counterSample{},
+	}
+}
+
+// rates returns the rate metrics for the counters in the given metrics
+func (c *counterRateCalculator) rates(collectedMetrics []metrics.Metric) []metrics.Metric {
+	now := c.clock.Now()
+	rateMetrics := []metrics.Metric{}
+
+	for _, m := range collectedMetrics {
+		if m.Kind != metrics.Counter {
+			continue
+		}
+		id := counterSeriesID(m)
+		prev, ok := c.previous[id]
+		c.previous[id] = counterSample{value: m.Value, time: now}
+		if !ok || m.Value < prev.value {
+			continue
+		}
+		elapsed := now.Sub(prev.time).Seconds()
+		if elapsed <= 0 {
+			continue
+		}
+
+		rateMetrics = append(rateMetrics, metrics.Metric{
+			Key:   m.Key + rateMetricSuffix,
+			Unit:  m.Unit + "/s",
+			Kind:  metrics.Gauge,
+			Value: (m.Value - prev.value) / elapsed,
+			Tags:  m.Tags,
+		})
+	}
+
+	for id, sample := range c.previous {
+		if now.Sub(sample.time) > counterSampleMaxAge {
+			delete(c.previous, id)
+		}
+	}
+	return rateMetrics
+}
+
+func counterSeriesID(m metrics.Metric) string {
+	tagNames := make([]string, 0, len(m.Tags))
+	for k := range m.Tags {
+		tagNames = append(tagNames, k)
+	}
+	sort.Strings(tagNames)
+
+	id := m.Key
+	for _, k := range tagNames {
+		id += "\x00" + k + "=" + m.Tags[k]
+	}
+	return id
+}
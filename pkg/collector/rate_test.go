@@ -0,0 +1,112 @@
+package collector
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-rds-metric-collector/pkg/metrics"
+)
+
+var _ = Describe("counterRateCalculator", func() {
+	var (
+		fakeClock      *fakeclock.FakeClock
+		rateCalculator *counterRateCalculator
+		tags           map[string]string
+	)
+
+	sample := func(commits, connections float64) []metrics.Metric {
+		return []metrics.Metric{
+			{Key: "commits", Value: commits, Unit: "tx", Kind: metrics.Counter, Tags: tags},
+			{Key: "connections", Value: connections, Unit: "conn", Kind: metrics.Gauge, Tags: tags},
+		}
+	}
+
+	BeforeEach(func() {
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		rateCalculator = newCounterRateCalculator(fakeClock)
+		tags = map[string]string{"source": "sql", "dbname": "foo"}
+	})
+
+	It("does not return any rate for the first sample", func() {
+		Expect(rateCalculator.rates(sample(100, 5))).To(BeEmpty())
+	})
+
+	It("returns the per-second rate of the counters only", func() {
+		rateCalculator.rates(sample(100, 5))
+		fakeClock.Increment(10 * time.Second)
+
+		Expect(rateCalculator.rates(sample(150, 7))).To(Equal([]metrics.Metric{
+			{Key: "commits_per_second", Value: 5, Unit: "tx/s", Kind: metrics.Gauge, Tags: tags},
+		}))
+	})
+
+	It("returns a zero rate if the counter did not change", func() {
+		rateCalculator.rates(sample(100, 5))
+		fakeClock.Increment(10 * time.Second)
+
+		rates := rateCalculator.rates(sample(100, 5))
+		Expect(rates).To(HaveLen(1))
+		Expect(rates[0].Value).To(BeNumerically("==", 0))
+	})
+
+	It("skips the rate and resets the baseline when the counter goes backwards", func() {
+		rateCalculator.rates(sample(100, 5))
+		fakeClock.Increment(10 * time.Second)
+
+		By("restarting the server")
+		Expect(rateCalculator.rates(sample(20, 5))).To(BeEmpty())
+
+		fakeClock.Increment(10 * time.Second)
+		rates := rateCalculator.rates(sample(40, 5))
+		Expect(rates).To(HaveLen(1))
+		Expect(rates[0].Value).To(BeNumerically("==", 2))
+	})
+
+	It("does not return a rate if no time has elapsed", func() {
+		rateCalculator.rates(sample(100, 5))
+		Expect(rateCalculator.rates(sample(150, 5))).To(BeEmpty())
+	})
+
+	It("tracks the same counter with different tags separately", func() {
+		rateCalculator.rates([]metrics.Metric{
+			{Key: "commits", Value: 100, Kind: metrics.Counter, Tags: map[string]string{"dbname": "a"}},
+		})
+		fakeClock.Increment(1 * time.Second)
+
+		rates := rateCalculator.rates([]metrics.Metric{
+			{Key: "commits", Value: 110, Kind: metrics.Counter, Tags: map[string]string{"dbname": "a"}},
+			{Key: "commits", Value: 500, Kind: metrics.Counter, Tags: map[string]string{"dbname": "b"}},
+		})
+		Expect(rates).To(HaveLen(1))
+		Expect(rates[0].Value).To(BeNumerically("==", 10))
+		Expect(rates[0].Tags).To(Equal(map[string]string{"dbname": "a"}))
+	})
+
+	It("keeps the baseline of the counters that are missing from a collection", func() {
+		rateCalculator.rates(sample(100, 5))
+		fakeClock.Increment(10 * time.Second)
+
+		By("failing the query of the counter for one cycle")
+		Expect(rateCalculator.rates(sample(0, 5)[1:])).To(BeEmpty())
+
+		fakeClock.Increment(10 * time.Second)
+		Expect(rateCalculator.rates(sample(200, 5))).To(Equal([]metrics.Metric{
+			{Key: "commits_per_second", Value: 5, Unit: "tx/s", Kind: metrics.Gauge, Tags: tags},
+		}))
+	})
+
+	It("forgets counters that are no longer collected", func() {
+		rateCalculator.rates(sample(100, 5))
+		fakeClock.Increment(1 * time.Second)
+		rateCalculator.rates([]metrics.Metric{})
+		fakeClock.Increment(counterSampleMaxAge)
+		rateCalculator.rates([]metrics.Metric{})
+
+		Expect(rateCalculator.previous).To(BeEmpty())
+		Expect(rateCalculator.rates(sample(150, 5))).To(BeEmpty())
+	})
+})
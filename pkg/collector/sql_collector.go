@@ -4,17 +4,56 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strconv"
 	"strings"
+	"sync/atomic"
 
+	"code.cloudfoundry.org/clock"
 	"code.cloudfoundry.org/lager/v3"
 
 	"github.com/alphagov/paas-rds-metric-collector/pkg/brokerinfo"
 	"github.com/alphagov/paas-rds-metric-collector/pkg/metrics"
+	"github.com/alphagov/paas-rds-metric-collector/pkg/utils"
 )
 
-// MetricQuery has a method to get the metrics for a query
+// MetricQuery has a method to get the metrics for a query, and declares the
+// server versions it can run on. Built-in queries are named after their
+// first metric.
 type metricQuery interface {
 	getMetrics(ctx context.Context, db *sql.DB) ([]metrics.Metric, error)
+	supportsServerVersion(v utils.Version) bool
+	name() string
+}
+
+// queryErrorMetricKey is the metric emitted for every query that fails, with
+// value 1. It is not emitted for the queries that succeed, as each query has
+// its own tags, so it would add an envelope per query to every collection.
+const queryErrorMetricKey = "query_error"
+
+// authFailureMetricKey is the metric emitted for every collection, failed or
+// not, with value 1 if the credentials were rejected and had to be fetched
+// again, and 0 otherwise
+const authFailureMetricKey = "auth_failure"
+
+// serverVersionRange is the range of server versions a query supports, from
+// MinServerVersion (included) to MaxServerVersion (excluded). A nil bound
+// means the range is open on that side.
+//
+// Queries embed it, so that the variants of a query for different server
+// versions can be listed together and only the right one runs.
+type serverVersionRange struct {
+	MinServerVersion *utils.Version
+	MaxServerVersion *utils.Version
+}
+
+func (r serverVersionRange) supportsServerVersion(v utils.Version) bool {
+	if r.MinServerVersion != nil && v.Compare(*r.MinServerVersion) < 0 {
+		return false
+	}
+	if r.MaxServerVersion != nil && v.Compare(*r.MaxServerVersion) >= 0 {
+		return false
+	}
+	return true
 }
 
 // Used to get the right connection string for each DB type
@@ -24,18 +63,55 @@ type sqlConnectionStringBuilder interface {
 
 // sqlMetricsCollectorDriver pulls metrics using generic SQL queries
 type sqlMetricsCollectorDriver struct {
-	collectInterval int
+	collectInterval int64
 	brokerInfo      brokerinfo.BrokerInfo
 	queries         []metricQuery
 	driver          string
 	name            string
+	clock           clock.Clock
 	logger          lager.Logger
 
+	// serverVersionQuery returns the server version as a single string
+	// value. If empty, the version is not detected and all queries run,
+	// whatever versions they support.
+	serverVersionQuery string
+
 	connectionStringBuilder sqlConnectionStringBuilder
+
+	// isAuthError tells if a connection error means the credentials were
+	// rejected. If nil, the credentials are never fetched again.
+	isAuthError func(error) bool
+
+	// authTokenBuilder builds the RDS IAM auth tokens used as passwords. If
+	// nil, the password of the connection details is used.
+	authTokenBuilder AuthTokenBuilder
 }
 
 // NewCollector ...
 func (d *sqlMetricsCollectorDriver) NewCollector(instanceInfo brokerinfo.InstanceInfo) (MetricsCollector, error) {
+	dbConn, err := d.openDB(instanceInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	sqlMetricsCollector := &sqlMetricsCollector{
+		logger:             d.logger,
+		queries:            d.queries,
+		dbConn:             dbConn,
+		serverVersionQuery: d.serverVersionQuery,
+		rateCalculator:     newCounterRateCalculator(d.clock),
+		isAuthError:        d.isAuthError,
+		openDB: func() (*sql.DB, error) {
+			return d.openDB(instanceInfo)
+		},
+	}
+
+	return sqlMetricsCollector, nil
+}
+
+// openDB fetches the connection details of the instance and opens a
+// database with them
+func (d *sqlMetricsCollectorDriver) openDB(instanceInfo brokerinfo.InstanceInfo) (*sql.DB, error) {
 	details, err := d.brokerInfo.GetInstanceConnectionDetails(instanceInfo)
 	if err != nil {
 		d.logger.Error("cannot compose connection string", err, lager.Data{
@@ -44,6 +120,10 @@ func (d *sqlMetricsCollectorDriver) NewCollector(instanceInfo brokerinfo.Instanc
 		return nil, err
 	}
 
+	if d.authTokenBuilder != nil {
+		return d.openDBWithAuthTokens(instanceInfo, details)
+	}
+
 	url := d.connectionStringBuilder.ConnectionString(details)
 
 	dbConn, err := sql.Open(d.driver, url)
@@ -53,14 +133,39 @@ func (d *sqlMetricsCollectorDriver) NewCollector(instanceInfo brokerinfo.Instanc
 		})
 		return nil, err
 	}
+	return dbConn, nil
+}
 
-	sqlMetricsCollector := &sqlMetricsCollector{
-		logger:  d.logger,
-		queries: d.queries,
-		dbConn:  dbConn,
+// openDBWithAuthTokens opens a database that connects with IAM auth tokens,
+// refreshed before they expire, instead of the password
+func (d *sqlMetricsCollectorDriver) openDBWithAuthTokens(
+	instanceInfo brokerinfo.InstanceInfo,
+	details brokerinfo.InstanceConnectionDetails,
+) (*sql.DB, error) {
+	// The driver is only registered by name, so it is looked up with a
+	// database that never connects
+	lookupDB, err := sql.Open(d.driver, "")
+	if err != nil {
+		d.logger.Error("cannot connect to the database", err, lager.Data{
+			"instanceInfo": instanceInfo,
+		})
+		return nil, err
 	}
-
-	return sqlMetricsCollector, nil
+	defer lookupDB.Close()
+
+	tokens := newAuthTokenSource(d.authTokenBuilder, details, d.clock)
+	return sql.OpenDB(&connectionStringConnector{
+		driver: lookupDB.Driver(),
+		connectionString: func() (string, error) {
+			token, err := tokens.get()
+			if err != nil {
+				return "", err
+			}
+			tokenDetails := details
+			tokenDetails.MasterPassword = token
+			return d.connectionStringBuilder.ConnectionString(tokenDetails), nil
+		},
+	}), nil
 }
 
 func (d *sqlMetricsCollectorDriver) GetName() string {
@@ -72,42 +177,174 @@ func (d *sqlMetricsCollectorDriver) SupportedTypes() []string {
 }
 
 func (d *sqlMetricsCollectorDriver) GetCollectInterval() int {
-	return d.collectInterval
+	return int(atomic.LoadInt64(&d.collectInterval))
+}
+
+// SetCollectInterval changes the interval returned by GetCollectInterval, so
+// it can be changed while the collectors run
+func (d *sqlMetricsCollectorDriver) SetCollectInterval(intervalSeconds int) {
+	atomic.StoreInt64(&d.collectInterval, int64(intervalSeconds))
 }
 
 type sqlMetricsCollector struct {
-	queries []metricQuery
-	dbConn  *sql.DB
-	logger  lager.Logger
+	queries            []metricQuery
+	dbConn             *sql.DB
+	serverVersionQuery string
+	serverVersion      *utils.Version
+	rateCalculator     *counterRateCalculator
+	logger             lager.Logger
+
+	// Used to open the database again with fresh credentials when the
+	// current ones are rejected, e.g. after the password was rotated
+	isAuthError func(error) bool
+	openDB      func() (*sql.DB, error)
 }
 
 func (mc *sqlMetricsCollector) Collect(ctx context.Context) ([]metrics.Metric, error) {
 	var metrics []metrics.Metric
+	authFailure := false
 	err := mc.dbConn.PingContext(ctx)
+	if err != nil && mc.isAuthError != nil && mc.isAuthError(err) {
+		mc.logger.Error("authentication failed, refreshing credentials", err)
+		authFailure = true
+		err = mc.reconnect(ctx)
+		if err != nil {
+			err = fmt.Errorf("authentication failed with refreshed credentials: %s", err)
+		}
+	}
 	if err != nil {
 		mc.logger.Error("connecting to db", err)
-		return metrics, err
+		return append(metrics, authFailureMetric(authFailure)), err
+	}
+	err = mc.detectServerVersion(ctx)
+	if err != nil {
+		mc.logger.Error("detecting server version", err)
+		return append(metrics, authFailureMetric(authFailure)), err
 	}
 	for _, q := range mc.queries {
+		if mc.serverVersion != nil && !q.supportsServerVersion(*mc.serverVersion) {
+			continue
+		}
 		newMetrics, err := q.getMetrics(ctx, mc.dbConn)
 		if err != nil {
-			mc.logger.Error("querying metrics", err, lager.Data{"query": q})
-			return nil, err
+			mc.logger.Error("querying metrics", err, lager.Data{"query": q.name()})
+			// The collection timed out or was cancelled, so the rest
+			// of the queries would fail too. Only the authentication
+			// status is returned, as the other metrics are incomplete.
+			if ctx.Err() != nil {
+				return append(metrics[:0], authFailureMetric(authFailure)), err
+			}
+			metrics = append(metrics, queryErrorMetric(q.name()))
+			continue
 		}
 
 		metrics = append(metrics, newMetrics...)
 	}
+	metrics = append(metrics, mc.rateCalculator.rates(metrics)...)
+	metrics = append(metrics, authFailureMetric(authFailure))
 	return metrics, nil
 }
 
+// reconnect replaces the database with one opened with fresh connection
+// details, and checks the new credentials work
+func (mc *sqlMetricsCollector) reconnect(ctx context.Context) error {
+	dbConn, err := mc.openDB()
+	if err != nil {
+		return err
+	}
+	if err := mc.dbConn.Close(); err != nil {
+		mc.logger.Error("closing stale connection", err)
+	}
+	mc.dbConn = dbConn
+	return mc.dbConn.PingContext(ctx)
+}
+
+// detectServerVersion queries the server version the first time the
+// collector connects
+func (mc *sqlMetricsCollector) detectServerVersion(ctx context.Context) error {
+	if mc.serverVersion != nil || mc.serverVersionQuery == "" {
+		return nil
+	}
+
+	var versionString string
+	err := mc.dbConn.QueryRowContext(ctx, mc.serverVersionQuery).Scan(&versionString)
+	if err != nil {
+		return fmt.Errorf("unable to execute query: %s", err)
+	}
+	v, err := utils.ParseVersion(versionString)
+	if err != nil {
+		return err
+	}
+
+	mc.logger.Info("detected_server_version", lager.Data{"version": v.String()})
+	mc.serverVersion = &v
+	return nil
+}
+
+// builtInMetricKeys returns the keys of the metrics of the queries, of the
+// rates derived from their counters, and of the metrics every SQL collection
+// sends, so that custom queries cannot use them
+func builtInMetricKeys(queries []metricQuery) []string {
+	keys := []string{queryErrorMetricKey, authFailureMetricKey}
+	addKeys := func(metas ...metricQueryMeta) {
+		for _, m := range metas {
+			keys = append(keys, m.Key)
+			if m.Kind == metrics.Counter {
+				keys = append(keys, m.Key+rateMetricSuffix)
+			}
+		}
+	}
+	for _, q := range queries {
+		switch q := q.(type) {
+		case *columnMetricQuery:
+			addKeys(q.Metrics...)
+		case *rowMetricQuery:
+			addKeys(q.Metrics...)
+		case *wideRowMetricQuery:
+			for _, m := range q.Metrics {
+				addKeys(m.metricQueryMeta)
+			}
+		}
+	}
+	return keys
+}
+
+func queryErrorMetric(queryName string) metrics.Metric {
+	return metrics.Metric{
+		Key:   queryErrorMetricKey,
+		Unit:  "bool",
+		Value: 1,
+		Tags: map[string]string{
+			"source": "sql",
+			"query":  queryName,
+		},
+	}
+}
+
+func authFailureMetric(failed bool) metrics.Metric {
+	value := 0.0
+	if failed {
+		value = 1
+	}
+	return metrics.Metric{
+		Key:   authFailureMetricKey,
+		Unit:  "bool",
+		Value: value,
+		Tags: map[string]string{
+			"source": "sql",
+		},
+	}
+}
+
 func (mc *sqlMetricsCollector) Close() error {
 	return mc.dbConn.Close()
 }
 
-// MetricQueryMeta Metric meta information (Key and unit)
+// MetricQueryMeta Metric meta information (Key, unit and kind)
 type metricQueryMeta struct {
 	Key  string
 	Unit string
+	Kind metrics.MetricKind
 }
 
 // The query retuns one metric per column in the form:
@@ -129,6 +366,11 @@ type metricQueryMeta struct {
 type columnMetricQuery struct {
 	Query   string
 	Metrics []metricQueryMeta
+	serverVersionRange
+}
+
+func (q *columnMetricQuery) name() string {
+	return firstMetricKey(q.Metrics)
 }
 
 // queryToMetrics Executes the given query and retunrs the result as
@@ -157,6 +399,7 @@ func (q *columnMetricQuery) getMetrics(ctx context.Context, db *sql.DB) ([]metri
 			rowMetrics = append(rowMetrics, metrics.Metric{
 				Key:   m.Key,
 				Unit:  m.Unit,
+				Kind:  m.Kind,
 				Value: v,
 				Tags:  tags,
 			})
@@ -178,6 +421,11 @@ func (q *columnMetricQuery) getMetrics(ctx context.Context, db *sql.DB) ([]metri
 type rowMetricQuery struct {
 	Query   string
 	Metrics []metricQueryMeta
+	serverVersionRange
+}
+
+func (q *rowMetricQuery) name() string {
+	return firstMetricKey(q.Metrics)
 }
 
 // queryToMetrics Executes the given query and returns the result as
@@ -236,6 +484,7 @@ func (q *rowMetricQuery) getMetrics(ctx context.Context, db *sql.DB) (resultMetr
 		resultMetrics = append(resultMetrics, metrics.Metric{
 			Key:   m.Key,
 			Unit:  m.Unit,
+			Kind:  m.Kind,
 			Value: v.Value,
 			Tags:  v.Tags,
 		})
@@ -244,8 +493,131 @@ func (q *rowMetricQuery) getMetrics(ctx context.Context, db *sql.DB) (resultMetr
 	return resultMetrics, nil
 }
 
+// The query returns many columns, of which only some are metrics, in zero or
+// more rows. This is the case of status commands like:
+//
+// mysql> SHOW REPLICA STATUS\G
+//
+//	*************************** 1. row ***************************
+//	             Replica_IO_State: Waiting for source to send event
+//	                  Source_Host: 10.0.0.1
+//	...
+//	           Replica_IO_Running: Yes
+//	          Replica_SQL_Running: Yes
+//	...
+//	              Relay_Log_Space: 1024
+//	...
+//	        Seconds_Behind_Source: 0
+//
+// Each metric is read from its Column, and it is skipped if the value is
+// NULL.
+type wideRowMetricQuery struct {
+	Query      string
+	Metrics    []wideRowMetricQueryMeta
+	TagColumns []string
+	serverVersionRange
+}
+
+// wideRowMetricQueryMeta Metric meta information and the column to read it
+// from. If Boolean is set, "Yes" is read as 1 and any other value as 0.
+type wideRowMetricQueryMeta struct {
+	metricQueryMeta
+	Column  string
+	Boolean bool
+}
+
+func (q *wideRowMetricQuery) name() string {
+	if len(q.Metrics) == 0 {
+		return ""
+	}
+	return q.Metrics[0].Key
+}
+
+func (q *wideRowMetricQuery) getMetrics(ctx context.Context, db *sql.DB) ([]metrics.Metric, error) {
+	rows, err := db.QueryContext(ctx, q.Query)
+	if err != nil {
+		return nil, fmt.Errorf("unable to execute query: %s", err)
+	}
+	defer rows.Close()
+
+	columnNames, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	columnIndex := make(map[string]int, len(columnNames))
+	for i, c := range columnNames {
+		columnIndex[strings.ToLower(c)] = i
+	}
+
+	rowMetrics := []metrics.Metric{}
+	for rows.Next() {
+		values := make([]sql.NullString, len(columnNames))
+		scanArgs := make([]interface{}, len(columnNames))
+		for i := range values {
+			scanArgs[i] = &values[i]
+		}
+		if err := rows.Scan(scanArgs...); err != nil {
+			return nil, err
+		}
+
+		tags := map[string]string{"source": "sql"}
+		for _, c := range q.TagColumns {
+			if i, ok := columnIndex[strings.ToLower(c)]; ok && values[i].Valid {
+				tags[strings.ToLower(c)] = values[i].String
+			}
+		}
+
+		for _, m := range q.Metrics {
+			value, ok, err := m.read(values, columnIndex)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read key '%s': %s", m.Key, err)
+			}
+			if !ok {
+				continue
+			}
+			rowMetrics = append(rowMetrics, metrics.Metric{
+				Key:   m.Key,
+				Unit:  m.Unit,
+				Kind:  m.Kind,
+				Value: value,
+				Tags:  tags,
+			})
+		}
+	}
+
+	return rowMetrics, rows.Err()
+}
+
+func (m *wideRowMetricQueryMeta) read(values []sql.NullString, columnIndex map[string]int) (float64, bool, error) {
+	i, ok := columnIndex[strings.ToLower(m.Column)]
+	if !ok {
+		return 0, false, fmt.Errorf("column %s not found", m.Column)
+	}
+	if !values[i].Valid {
+		return 0, false, nil
+	}
+	if m.Boolean {
+		if strings.EqualFold(values[i].String, "yes") {
+			return 1, true, nil
+		}
+		return 0, true, nil
+	}
+	v, err := strconv.ParseFloat(values[i].String, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	return v, true, nil
+}
+
 // Helpers
 
+func firstMetricKey(metricsMeta []metricQueryMeta) string {
+	if len(metricsMeta) == 0 {
+		return ""
+	}
+	return metricsMeta[0].Key
+}
+
 // getRowDataAsMaps Returns a sql.Rows row and returns two maps with values
 // as map[string]float64 or tags as map[string]string.
 //
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"time"
 
+	"code.cloudfoundry.org/clock"
 	_ "github.com/Kount/pq-timeouts"
 	"github.com/stretchr/testify/mock"
 
@@ -15,6 +16,7 @@ import (
 	"github.com/alphagov/paas-rds-metric-collector/pkg/brokerinfo"
 	"github.com/alphagov/paas-rds-metric-collector/pkg/brokerinfo/fakebrokerinfo"
 	"github.com/alphagov/paas-rds-metric-collector/pkg/metrics"
+	"github.com/alphagov/paas-rds-metric-collector/pkg/utils"
 )
 
 type fakeSqlConnectionStringBuilder struct {
@@ -60,6 +62,9 @@ var badColumnQueries = map[string]metricQuery{
 	},
 	"invalid_query": &columnMetricQuery{
 		Query: "SELECT * FROM hell",
+		Metrics: []metricQueryMeta{
+			{Key: "hell", Unit: "gauge"},
+		},
 	},
 	"not_a_number": &columnMetricQuery{
 		Query: "SELECT 'Hello World' as foo2",
@@ -179,6 +184,8 @@ var _ = Describe("sql_collector", func() {
 		collectorErr            error
 		connectionStringBuilder sqlConnectionStringBuilder
 		driver                  string
+		serverVersionQuery      string
+		authTokenBuilder        AuthTokenBuilder
 	)
 	BeforeEach(func() {
 		brokerInfo = &fakebrokerinfo.FakeBrokerInfo{}
@@ -198,6 +205,8 @@ var _ = Describe("sql_collector", func() {
 		}
 
 		driver = "pq-timeouts"
+		serverVersionQuery = ""
+		authTokenBuilder = nil
 
 		connectionStringBuilder = &fakeSqlConnectionStringBuilder{
 			connectionString: postgresTestDatabaseConnectionURL,
@@ -210,8 +219,12 @@ var _ = Describe("sql_collector", func() {
 			driver:                  driver,
 			brokerInfo:              brokerInfo,
 			name:                    "sql",
+			clock:                   clock.NewClock(),
 			logger:                  logger,
+			serverVersionQuery:      serverVersionQuery,
 			connectionStringBuilder: connectionStringBuilder,
+			isAuthError:             isPostgresAuthError,
+			authTokenBuilder:        authTokenBuilder,
 		}
 
 		collector, collectorErr = metricsCollectorDriver.NewCollector(brokerinfo.InstanceInfo{GUID: "instance-guid1"})
@@ -271,15 +284,70 @@ var _ = Describe("sql_collector", func() {
 				metrics.Metric{Key: "bar", Value: 2, Unit: "s", Tags: expectedTags1},
 				metrics.Metric{Key: "baz", Value: 3, Unit: "conn", Tags: expectedTags1},
 				metrics.Metric{Key: "foo2", Value: 1, Unit: "gauge", Tags: expectedTags2},
+				metrics.Metric{Key: "auth_failure", Value: 0, Unit: "bool", Tags: map[string]string{"source": "sql"}},
 			))
 		})
 
-		Context("given a bad query", func() {
+		Context("given queries restricted to some server versions", func() {
+			BeforeEach(func() {
+				serverVersionQuery = "SELECT '11.2 (Debian 11.2-1)'"
+				testColumnQueriesSlice = []metricQuery{
+					&customMetricQuery{
+						Name:               "old",
+						Query:              testColumnQueries["single_value"],
+						serverVersionRange: serverVersionRange{MinServerVersion: &utils.Version{9, 6, 0}},
+					},
+					&customMetricQuery{
+						Name:               "new",
+						Query:              testColumnQueries["multi_value"],
+						serverVersionRange: serverVersionRange{MinServerVersion: &utils.Version{12, 0, 0}},
+					},
+				}
+			})
+
+			It("detects the server version and skips the unsupported queries", func() {
+				collectedMetrics, err := collector.Collect(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(collectedMetrics).To(ConsistOf(
+					metrics.Metric{Key: "foo2", Value: 1, Unit: "gauge", Tags: map[string]string{"source": "sql"}},
+					metrics.Metric{Key: "auth_failure", Value: 0, Unit: "bool", Tags: map[string]string{"source": "sql"}},
+				))
+				Expect(collector.(*sqlMetricsCollector).serverVersion).To(Equal(&utils.Version{11, 2, 0}))
+			})
+		})
+
+		Context("when the server version cannot be detected", func() {
 			BeforeEach(func() {
-				testColumnQueriesSlice = []metricQuery{badColumnQueries["invalid_query"]}
+				serverVersionQuery = "SELECT 'unknown'"
 			})
+
 			It("returns with an error", func() {
 				_, err := collector.Collect(context.Background())
+				Expect(err).To(MatchError(ContainSubstring("cannot parse version")))
+			})
+		})
+
+		Context("given a bad query", func() {
+			BeforeEach(func() {
+				testColumnQueriesSlice = []metricQuery{
+					badColumnQueries["invalid_query"],
+					testColumnQueries["single_value"],
+				}
+			})
+			It("returns the metrics of the other queries and an error metric for the bad one", func() {
+				collectedMetrics, err := collector.Collect(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(collectedMetrics).To(ConsistOf(
+					metrics.Metric{Key: "query_error", Value: 1, Unit: "bool", Tags: map[string]string{"source": "sql", "query": "hell"}},
+					metrics.Metric{Key: "foo2", Value: 1, Unit: "gauge", Tags: map[string]string{"source": "sql"}},
+					metrics.Metric{Key: "auth_failure", Value: 0, Unit: "bool", Tags: map[string]string{"source": "sql"}},
+				))
+			})
+
+			It("returns with an error if the collection is cancelled", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				_, err := collector.Collect(ctx)
 				Expect(err).To(HaveOccurred())
 			})
 		})
@@ -304,6 +372,124 @@ var _ = Describe("sql_collector", func() {
 				Expect(err).To(MatchError(MatchRegexp("connect")))
 			})
 		})
+
+		Context("with IAM auth tokens", func() {
+			var tokenBuilder *stubAuthTokenBuilder
+
+			BeforeEach(func() {
+				driver = "fake-auth"
+				connectionStringBuilder = passwordConnectionStringBuilder{}
+				testColumnQueriesSlice = []metricQuery{}
+
+				brokerInfo.ExpectedCalls = nil
+				brokerInfo.On(
+					"GetInstanceConnectionDetails", mock.Anything,
+				).Return(
+					brokerinfo.InstanceConnectionDetails{
+						DBAddress:      "endpoint-address.example.com",
+						DBPort:         5432,
+						MasterUsername: "master-username",
+						MasterPassword: "master-password",
+					}, nil,
+				)
+
+				tokenBuilder = &stubAuthTokenBuilder{}
+				authTokenBuilder = tokenBuilder.build
+				fakeAuth.setPassword("token-1")
+			})
+
+			It("connects with a token instead of the password", func() {
+				collectedMetrics, err := collector.Collect(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(collectedMetrics).To(ConsistOf(
+					metrics.Metric{Key: "auth_failure", Value: 0, Unit: "bool", Tags: map[string]string{"source": "sql"}},
+				))
+				Expect(tokenBuilder.endpoints).To(Equal([]string{"endpoint-address.example.com:5432"}))
+				Expect(tokenBuilder.dbUsers).To(Equal([]string{"master-username"}))
+			})
+
+			It("builds a new token when the token is rejected", func() {
+				fakeAuth.setPassword("token-2")
+				collectedMetrics, err := collector.Collect(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(collectedMetrics).To(ConsistOf(
+					metrics.Metric{Key: "auth_failure", Value: 1, Unit: "bool", Tags: map[string]string{"source": "sql"}},
+				))
+			})
+		})
+
+		Context("when the credentials are rejected", func() {
+			BeforeEach(func() {
+				driver = "fake-auth"
+				connectionStringBuilder = passwordConnectionStringBuilder{}
+				testColumnQueriesSlice = []metricQuery{}
+
+				brokerInfo.ExpectedCalls = nil
+				brokerInfo.On(
+					"GetInstanceConnectionDetails", mock.Anything,
+				).Return(
+					brokerinfo.InstanceConnectionDetails{MasterPassword: "old-password"}, nil,
+				).Once()
+				brokerInfo.On(
+					"GetInstanceConnectionDetails", mock.Anything,
+				).Return(
+					brokerinfo.InstanceConnectionDetails{MasterPassword: "new-password"}, nil,
+				)
+
+				fakeAuth.setPassword("new-password")
+			})
+
+			It("fetches the connection details again and reports the authentication failure", func() {
+				collectedMetrics, err := collector.Collect(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(collectedMetrics).To(ConsistOf(
+					metrics.Metric{Key: "auth_failure", Value: 1, Unit: "bool", Tags: map[string]string{"source": "sql"}},
+				))
+				brokerInfo.AssertNumberOfCalls(GinkgoT(), "GetInstanceConnectionDetails", 2)
+
+				collectedMetrics, err = collector.Collect(context.Background())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(collectedMetrics).To(ConsistOf(
+					metrics.Metric{Key: "auth_failure", Value: 0, Unit: "bool", Tags: map[string]string{"source": "sql"}},
+				))
+				brokerInfo.AssertNumberOfCalls(GinkgoT(), "GetInstanceConnectionDetails", 2)
+			})
+
+			Context("when the fresh credentials are rejected too", func() {
+				BeforeEach(func() {
+					fakeAuth.setPassword("newer-password")
+				})
+
+				It("returns with an error and reports the authentication failure", func() {
+					collectedMetrics, err := collector.Collect(context.Background())
+					Expect(err).To(MatchError(ContainSubstring("authentication failed with refreshed credentials")))
+					Expect(collectedMetrics).To(ConsistOf(
+						metrics.Metric{Key: "auth_failure", Value: 1, Unit: "bool", Tags: map[string]string{"source": "sql"}},
+					))
+				})
+			})
+
+			Context("when the connection details cannot be fetched again", func() {
+				BeforeEach(func() {
+					brokerInfo.ExpectedCalls = nil
+					brokerInfo.On(
+						"GetInstanceConnectionDetails", mock.Anything,
+					).Return(
+						brokerinfo.InstanceConnectionDetails{MasterPassword: "old-password"}, nil,
+					).Once()
+					brokerInfo.On(
+						"GetInstanceConnectionDetails", mock.Anything,
+					).Return(
+						brokerinfo.InstanceConnectionDetails{}, fmt.Errorf("broker unavailable"),
+					)
+				})
+
+				It("returns with an error", func() {
+					_, err := collector.Collect(context.Background())
+					Expect(err).To(MatchError(ContainSubstring("broker unavailable")))
+				})
+			})
+		})
 	})
 })
 
@@ -420,6 +606,71 @@ var _ = Describe("metricQuery", func() {
 		})
 	})
 
+	Context("wideRowMetricQuery.getMetrics()", func() {
+		var query *wideRowMetricQuery
+
+		BeforeEach(func() {
+			query = mysqlReplicaStatusQuery(
+				`SELECT
+					'Yes' as Slave_IO_Running,
+					'Connecting' as Slave_SQL_Running,
+					NULL as Seconds_Behind_Master,
+					'1024' as relay_log_space,
+					'Ignored' as Master_Host,
+					'chan1' as Channel_Name`,
+				"Seconds_Behind_Master", "Slave_IO_Running", "Slave_SQL_Running",
+				serverVersionRange{},
+			).(*wideRowMetricQuery)
+		})
+
+		It("should read the metrics from the columns", func() {
+			rowMetrics, err := query.getMetrics(context.Background(), dbConn)
+
+			Expect(err).NotTo(HaveOccurred())
+			expectedTags := map[string]string{"source": "sql", "channel_name": "chan1"}
+			Expect(rowMetrics).To(Equal([]metrics.Metric{
+				{Key: "replica_io_running", Value: 1, Unit: "bool", Tags: expectedTags},
+				{Key: "replica_sql_running", Value: 0, Unit: "bool", Tags: expectedTags},
+				{Key: "relay_log_space", Value: 1024, Unit: "byte", Tags: expectedTags},
+			}))
+		})
+
+		It("should not return any metric if the query returns no rows", func() {
+			query.Query = "SELECT 'Yes' as Slave_IO_Running WHERE 1 = 2"
+
+			rowMetrics, err := query.getMetrics(context.Background(), dbConn)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rowMetrics).To(BeEmpty())
+		})
+
+		It("should error if the query fails", func() {
+			query.Query = "SHOW SLAVE STATUS"
+
+			_, err := query.getMetrics(context.Background(), dbConn)
+			Expect(err).To(MatchError(MatchRegexp("unable to execute query")))
+		})
+
+		It("should error if a metric column is missing", func() {
+			query.Query = "SELECT 'Yes' as Slave_IO_Running"
+
+			_, err := query.getMetrics(context.Background(), dbConn)
+			Expect(err).To(MatchError(MatchRegexp("unable to read key 'seconds_behind_master'")))
+		})
+
+		It("should error if a value is not a number", func() {
+			query.Query = `
+				SELECT
+					'Yes' as Slave_IO_Running,
+					'Yes' as Slave_SQL_Running,
+					'soon' as Seconds_Behind_Master,
+					'1024' as Relay_Log_Space
+			`
+
+			_, err := query.getMetrics(context.Background(), dbConn)
+			Expect(err).To(MatchError(MatchRegexp("invalid syntax")))
+		})
+	})
+
 	Context("getRowDataAsMaps()", func() {
 		It("should error when unexpected type from database", func() {
 			rows, err := dbConn.Query("SELECT 'Hello World'")
@@ -527,3 +778,20 @@ var _ = Describe("metricQuery", func() {
 	})
 
 })
+
+var _ = Describe("serverVersionRange", func() {
+	It("supports any version if it has no bounds", func() {
+		Expect(serverVersionRange{}.supportsServerVersion(utils.Version{9, 6, 0})).To(BeTrue())
+	})
+
+	It("includes the minimum version and excludes the maximum version", func() {
+		r := serverVersionRange{
+			MinServerVersion: &utils.Version{10, 0, 0},
+			MaxServerVersion: &utils.Version{13, 0, 0},
+		}
+		Expect(r.supportsServerVersion(utils.Version{9, 6, 21})).To(BeFalse())
+		Expect(r.supportsServerVersion(utils.Version{10, 0, 0})).To(BeTrue())
+		Expect(r.supportsServerVersion(utils.Version{12, 8, 0})).To(BeTrue())
+		Expect(r.supportsServerVersion(utils.Version{13, 0, 0})).To(BeFalse())
+	})
+})
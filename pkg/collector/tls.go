@@ -0,0 +1,63 @@
+package collector
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// mysqlVerifyFullTLSConfig is the name of the TLS config registered in the
+// MySQL driver to check the server certificates with the CA bundle
+const mysqlVerifyFullTLSConfig = "verify-full"
+
+// LoadCABundle reads the certificates of a PEM CA bundle, such as the RDS
+// CA bundle
+func LoadCABundle(caBundleFile string) (*x509.CertPool, error) {
+	contents, err := ioutil.ReadFile(caBundleFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA bundle: %s", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(contents) {
+		return nil, fmt.Errorf("no certificate found in CA bundle %s", caBundleFile)
+	}
+	return pool, nil
+}
+
+// newVerifyFullTLSConfig returns a TLS config checking the server
+// certificates with the CA bundle. The MySQL driver checks the host name
+// against the certificate, as the server name is left empty.
+func newVerifyFullTLSConfig(caBundleFile string) (*tls.Config, error) {
+	pool, err := LoadCABundle(caBundleFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+	}, nil
+}
+
+// MysqlTLSParam returns the tls parameter of the MySQL connection strings for
+// the TLS mode. For verify-full, it registers a TLS config checking the server
+// certificates with the CA bundle.
+func MysqlTLSParam(tlsMode string, caBundleFile string) (string, error) {
+	switch tlsMode {
+	case "disable":
+		return "false", nil
+	case "verify-full":
+		tlsConfig, err := newVerifyFullTLSConfig(caBundleFile)
+		if err != nil {
+			return "", err
+		}
+		if err := mysql.RegisterTLSConfig(mysqlVerifyFullTLSConfig, tlsConfig); err != nil {
+			return "", err
+		}
+		return mysqlVerifyFullTLSConfig, nil
+	default:
+		return tlsMode, nil
+	}
+}
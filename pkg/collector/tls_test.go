@@ -0,0 +1,103 @@
+package collector
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+
+	"github.com/go-sql-driver/mysql"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/alphagov/paas-rds-metric-collector/pkg/config"
+)
+
+func readFixtureCert(path string) *x509.Certificate {
+	contents, err := ioutil.ReadFile(path)
+	Expect(err).NotTo(HaveOccurred())
+	block, _ := pem.Decode(contents)
+	Expect(block).NotTo(BeNil())
+	cert, err := x509.ParseCertificate(block.Bytes)
+	Expect(err).NotTo(HaveOccurred())
+	return cert
+}
+
+var _ = Describe("LoadCABundle()", func() {
+	It("loads the certificates of the bundle", func() {
+		pool, err := LoadCABundle("../../fixtures/ca.cert.pem")
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = readFixtureCert("../../fixtures/loggregator-server.cert.pem").Verify(x509.VerifyOptions{
+			Roots:   pool,
+			DNSName: "metron",
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("fails if the bundle has no certificate", func() {
+		_, err := LoadCABundle("../../fixtures/invalid-cert.data")
+		Expect(err).To(MatchError(ContainSubstring("no certificate found")))
+	})
+
+	It("fails if the bundle does not exist", func() {
+		_, err := LoadCABundle("../../fixtures/missing.pem")
+		Expect(err).To(MatchError(ContainSubstring("reading CA bundle")))
+	})
+})
+
+var _ = Describe("newVerifyFullTLSConfig()", func() {
+	It("checks the server certificates with the CA bundle", func() {
+		tlsConfig, err := newVerifyFullTLSConfig("../../fixtures/ca.cert.pem")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tlsConfig.InsecureSkipVerify).To(BeFalse())
+
+		cert := readFixtureCert("../../fixtures/loggregator-server.cert.pem")
+		_, err = cert.Verify(x509.VerifyOptions{Roots: tlsConfig.RootCAs, DNSName: "metron"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = cert.Verify(x509.VerifyOptions{Roots: tlsConfig.RootCAs, DNSName: "other-host"})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("MysqlTLSParam()", func() {
+	It("disables TLS", func() {
+		Expect(MysqlTLSParam("disable", "")).To(Equal("false"))
+	})
+
+	It("skips the verification of the server certificates", func() {
+		Expect(MysqlTLSParam("skip-verify", "")).To(Equal("skip-verify"))
+	})
+
+	It("registers a TLS config with the CA bundle for verify-full", func() {
+		tlsParam, err := MysqlTLSParam("verify-full", "../../fixtures/ca.cert.pem")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(tlsParam).To(Equal("verify-full"))
+
+		_, err = mysql.ParseDSN("user:password@tcp(metron:3306)/db?tls=" + tlsParam)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("fails if the CA bundle cannot be loaded for verify-full", func() {
+		_, err := MysqlTLSParam("verify-full", "../../fixtures/invalid-cert.data")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("NewPostgresMetricsCollectorDriver() TLS", func() {
+	sslRootCert := func(sslMode string) string {
+		driver := NewPostgresMetricsCollectorDriver(
+			nil, 5, 10, "../../fixtures/ca.cert.pem",
+			config.PostgresCollectorConfig{SSLMode: sslMode},
+			nil, nil, logger,
+		).(*sqlMetricsCollectorDriver)
+		return driver.connectionStringBuilder.(*postgresConnectionStringBuilder).SSLRootCert
+	}
+
+	It("only uses the CA bundle to verify the server certificates", func() {
+		Expect(sslRootCert("verify-full")).To(Equal("../../fixtures/ca.cert.pem"))
+		Expect(sslRootCert("verify-ca")).To(Equal("../../fixtures/ca.cert.pem"))
+		Expect(sslRootCert("require")).To(BeEmpty())
+		Expect(sslRootCert("disable")).To(BeEmpty())
+	})
+})
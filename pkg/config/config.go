@@ -6,17 +6,31 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"regexp"
+	"strings"
 
 	"code.cloudfoundry.org/locket"
 	validator "gopkg.in/go-playground/validator.v9"
+
+	"github.com/alphagov/paas-rds-metric-collector/pkg/utils"
 )
 
 type Config struct {
 	LogLevel           string                   `json:"log_level" validate:"required"`
 	AWS                AWSConfig                `json:"aws"`
+	BrokerInfo         string                   `json:"broker_info,omitempty" validate:"required,oneof=rds static"`
 	RDSBrokerInfo      RDSBrokerInfoConfig      `json:"rds_broker"`
+	StaticBrokerInfo   StaticBrokerInfoConfig   `json:"static_broker"`
 	Scheduler          SchedulerConfig          `json:"scheduler"`
+	PostgresCollector  PostgresCollectorConfig  `json:"postgres_collector"`
+	MysqlCollector     MysqlCollectorConfig     `json:"mysql_collector"`
+	DBCABundleFile     string                   `json:"db_ca_bundle_file"`
+	MetricTags         MetricTagsConfig         `json:"metric_tags"`
+	CustomQueries      []CustomQueryConfig      `json:"custom_queries" validate:"dive"`
+	Emitters           []string                 `json:"emitters,omitempty" validate:"required,min=1,dive,oneof=loggregator prometheus stdout"`
 	LoggregatorEmitter LoggregatorEmitterConfig `json:"loggregator_emitter"`
+	PrometheusEmitter  PrometheusEmitterConfig  `json:"prometheus_emitter"`
+	OperatorAPI        OperatorAPIConfig        `json:"operator_api"`
 	locket.ClientLocketConfig
 }
 
@@ -28,7 +42,47 @@ type AWSConfig struct {
 type RDSBrokerInfoConfig struct {
 	DBPrefix           string `json:"db_prefix" validate:"required"`
 	BrokerName         string `json:"broker_name" validate:"required"`
-	MasterPasswordSeed string `json:"master_password_seed" validate:"required"`
+	MasterPasswordSeed string `json:"master_password_seed" validate:"required_without=MasterPasswordSeedFile"`
+
+	MasterPasswordSeedFile string `json:"master_password_seed_file" secret_file:"MasterPasswordSeed"`
+
+	MonitoringUser MonitoringUserConfig `json:"monitoring_user"`
+}
+
+// MonitoringUserConfig makes the collector connect to the instances as a
+// dedicated monitoring user rather than as the master user. The password of
+// the user is derived from a seed, read from a file of passwords by instance
+// GUID, or read from AWS Secrets Manager.
+type MonitoringUserConfig struct {
+	Enabled          bool   `json:"enabled"`
+	Username         string `json:"username"`
+	PasswordSource   string `json:"password_source" validate:"omitempty,oneof=seed file secrets_manager"`
+	PasswordSeed     string `json:"password_seed"`
+	PasswordsFile    string `json:"passwords_file"`
+	SecretNamePrefix string `json:"secret_name_prefix"`
+
+	PasswordSeedFile string `json:"password_seed_file" secret_file:"PasswordSeed"`
+}
+
+// StaticBrokerInfoConfig lists the instances to collect metrics from when
+// they are not RDS instances of the broker, such as local databases
+type StaticBrokerInfoConfig struct {
+	Instances []StaticInstanceConfig `json:"instances" validate:"required,min=1,dive"`
+}
+
+// StaticInstanceConfig is a database instance and the credentials to connect
+// to it. Tags are reported like the tags of RDS instances.
+type StaticInstanceConfig struct {
+	GUID     string            `json:"guid" validate:"required"`
+	Engine   string            `json:"engine" validate:"required,oneof=postgres mysql"`
+	Address  string            `json:"address" validate:"required"`
+	Port     int64             `json:"port" validate:"required,gte=1,lte=65535"`
+	DBName   string            `json:"db_name" validate:"required"`
+	Username string            `json:"username" validate:"required"`
+	Password string            `json:"password"`
+	Tags     map[string]string `json:"tags"`
+
+	PasswordFile string `json:"password_file" secret_file:"Password"`
 }
 
 type SchedulerConfig struct {
@@ -36,8 +90,88 @@ type SchedulerConfig struct {
 	CollectorTimeoutMs         *int `json:"collector_timeout_ms" validate:"isdefault,gte=0,lte=15000"`
 	CollectorRetryIntervalMs   *int `json:"collector_retry_interval_ms" validate:"isdefault,gte=0,lte=10000"`
 	CollectorMaxRetries        *int `json:"collector_max_retries" validate:"isdefault,gte=0,lte=10"`
+	WorkerRestartBackoffMs     *int `json:"worker_restart_backoff_ms" validate:"omitempty,gte=0,lte=60000"`
+	WorkerRestartMaxBackoffMs  *int `json:"worker_restart_max_backoff_ms" validate:"omitempty,gte=0,lte=3600000"`
 	SQLMetricCollectorInterval int  `json:"sql_metrics_collector_interval" validate:"required,gte=0,lte=3600"`
 	CWMetricCollectorInterval  int  `json:"cloudwatch_metrics_collector_interval" validate:"required,gte=0,lte=3600"`
+
+	IntervalOverrides []IntervalOverrideConfig `json:"interval_overrides" validate:"dive"`
+}
+
+// IntervalOverrideConfig sets the collection interval, in seconds, of the
+// instances with the given GUID, the given RDS tag value or the given engine.
+// Exactly one of them must be set. If Driver is set, only the collector of
+// that driver is changed.
+type IntervalOverrideConfig struct {
+	InstanceGUID string `json:"instance_guid"`
+	TagKey       string `json:"tag_key"`
+	TagValue     string `json:"tag_value"`
+	Engine       string `json:"engine" validate:"omitempty,oneof=postgres mysql"`
+	Driver       string `json:"driver" validate:"omitempty,oneof=postgres mysql cloudwatch"`
+	Interval     int    `json:"interval" validate:"required,gte=1,lte=3600"`
+}
+
+// PostgresCollectorConfig configures the PostgreSQL collector. SSLMode is
+// the sslmode of the connections, and the verify modes check the server
+// certificates with the DB CA bundle.
+type PostgresCollectorConfig struct {
+	TableMetricsMaxTables int    `json:"table_metrics_max_tables" validate:"gte=0,lte=1000"`
+	StatementMetrics      bool   `json:"statement_metrics"`
+	IAMAuth               bool   `json:"iam_auth"`
+	SSLMode               string `json:"ssl_mode,omitempty" validate:"required,oneof=disable require verify-ca verify-full"`
+}
+
+// MysqlCollectorConfig configures the MySQL collector. IAMAuth makes it
+// connect with RDS IAM auth tokens instead of passwords. TLSMode is either
+// disable, skip-verify, or verify-full to check the server certificates with
+// the DB CA bundle.
+type MysqlCollectorConfig struct {
+	IAMAuth bool   `json:"iam_auth"`
+	TLSMode string `json:"tls_mode,omitempty" validate:"required,oneof=disable skip-verify verify-full"`
+}
+
+// VerifiesServerCertificates returns true if the server certificates are
+// checked with the DB CA bundle
+func (c PostgresCollectorConfig) VerifiesServerCertificates() bool {
+	return c.SSLMode == "verify-ca" || c.SSLMode == "verify-full"
+}
+
+// VerifiesServerCertificates returns true if the server certificates are
+// checked with the DB CA bundle
+func (c MysqlCollectorConfig) VerifiesServerCertificates() bool {
+	return c.TLSMode == "verify-full"
+}
+
+// MetricTagsConfig lists the details of the instances added as tags to their
+// metrics. InstanceMetadata lists metadata fields, added as tags of the same
+// name. InstanceTags lists the keys of the tags of the instances, such as
+// "Plan ID", added as tags named in snake case, such as "plan_id".
+type MetricTagsConfig struct {
+	InstanceMetadata []string `json:"instance_metadata" validate:"dive,oneof=engine engine_version instance_class multi_az"`
+	InstanceTags     []string `json:"instance_tags" validate:"dive,required"`
+}
+
+// CustomQueryConfig is an operator defined SQL query run by the collector
+// of the given engine. A "column" query returns one metric per column, named
+// after the metric keys, while a "row" query returns one metric per row as
+// (key, value) pairs. In both cases the remaining columns are tags, and
+// TagColumns restricts which of them are kept. The query only runs on servers
+// from MinServerVersion (included) to MaxServerVersion (excluded), if set.
+type CustomQueryConfig struct {
+	Name             string                    `json:"name" validate:"required"`
+	Engine           string                    `json:"engine" validate:"required,oneof=postgres mysql"`
+	Type             string                    `json:"type" validate:"required,oneof=column row"`
+	Query            string                    `json:"query" validate:"required"`
+	Metrics          []CustomQueryMetricConfig `json:"metrics" validate:"required,min=1,dive"`
+	TagColumns       []string                  `json:"tag_columns" validate:"dive,required"`
+	MinServerVersion string                    `json:"min_server_version"`
+	MaxServerVersion string                    `json:"max_server_version"`
+}
+
+type CustomQueryMetricConfig struct {
+	Key  string `json:"key" validate:"required"`
+	Unit string `json:"unit" validate:"required"`
+	Kind string `json:"kind" validate:"omitempty,oneof=gauge counter"`
 }
 
 type LoggregatorEmitterConfig struct {
@@ -47,9 +181,23 @@ type LoggregatorEmitterConfig struct {
 	KeyPath    string `json:"client_key" validate:"required"`
 }
 
+type PrometheusEmitterConfig struct {
+	ListenAddress string `json:"listen_address" validate:"omitempty,tcp_addr"`
+	// SeriesTTLSeconds is how long a series is served after its last update.
+	// It must be longer than the longest collection interval.
+	SeriesTTLSeconds int `json:"series_ttl_seconds,omitempty" validate:"omitempty,gte=1"`
+}
+
+// OperatorAPIConfig is the HTTP endpoint that tells operators how the
+// collector is doing. It is disabled if ListenAddress is empty.
+type OperatorAPIConfig struct {
+	ListenAddress string `json:"listen_address" validate:"omitempty,tcp_addr"`
+}
+
 const defaultConfig = `
 {
 	"log_level": "INFO",
+	"broker_info": "rds",
 	"aws": {
 		"aws_partition": "aws"
 	},
@@ -58,13 +206,50 @@ const defaultConfig = `
 		"sql_metrics_collector_interval": 180,
 		"cloudwatch_metrics_collector_interval": 300
 	},
+	"postgres_collector": {
+		"ssl_mode": "require"
+	},
+	"mysql_collector": {
+		"tls_mode": "skip-verify"
+	},
+	"emitters": ["loggregator"],
 	"loggregator_emitter": {
 		"url": "localhost:3458"
+	},
+	"prometheus_emitter": {
+		"series_ttl_seconds": 900
 	}
 }
 `
 
+// LoadConfig reads the config from the defaults, then the config file, then
+// the environment variables, each of them overriding the previous ones. The
+// secrets are read from their files after the config is validated, and are
+// then checked by ValidateSecrets.
 func LoadConfig(configFile string) (*Config, error) {
+	config, err := ReadConfig(configFile)
+	if err != nil {
+		return config, err
+	}
+
+	if err = config.Validate(); err != nil {
+		return config, fmt.Errorf("Validating config contents: %s", err)
+	}
+
+	if err = config.ReadSecretFiles(); err != nil {
+		return config, err
+	}
+
+	if err = config.ValidateSecrets(); err != nil {
+		return config, fmt.Errorf("Validating config contents: %s", err)
+	}
+
+	return config, nil
+}
+
+// ReadConfig reads the config like LoadConfig, but neither validates it nor
+// reads the secret files
+func ReadConfig(configFile string) (*Config, error) {
 	var config Config
 
 	if configFile == "" {
@@ -87,15 +272,284 @@ func LoadConfig(configFile string) (*Config, error) {
 		return &config, err
 	}
 
-	if err = config.Validate(); err != nil {
-		return &config, fmt.Errorf("Validating config contents: %s", err)
+	if err = config.ApplyEnv(os.Environ()); err != nil {
+		return &config, err
 	}
 
 	return &config, nil
 }
 
+// Validate returns the first problem found in the config
 func (c Config) Validate() error {
-	validate := validator.New()
+	for _, check := range c.checks() {
+		if err := check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Problems returns every problem found in the config, rather than only the
+// first one
+func (c Config) Problems() []error {
+	problems := []error{}
+	for _, check := range c.checks() {
+		err := check()
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			for _, e := range validationErrors {
+				problems = append(problems, fieldProblem(e))
+			}
+		} else if err != nil {
+			problems = append(problems, err)
+		}
+	}
+	return problems
+}
+
+func (c Config) checks() []func() error {
+	return []func() error{
+		func() error {
+			validate := validator.New()
+			validate.RegisterTagNameFunc(jsonName)
+			unused := []string{"StaticBrokerInfo"}
+			if c.BrokerInfo == "static" {
+				unused = []string{"AWS", "RDSBrokerInfo"}
+			}
+			if !c.HasEmitter("loggregator") {
+				unused = append(unused, "LoggregatorEmitter")
+			}
+			return validate.StructExcept(c, unused...)
+		},
+		func() error {
+			if c.HasEmitter("prometheus") && c.PrometheusEmitter.ListenAddress == "" {
+				return errors.New("prometheus_emitter.listen_address is required when the prometheus emitter is enabled")
+			}
+			return nil
+		},
+		func() error {
+			ttl := c.PrometheusEmitter.SeriesTTLSeconds
+			if c.HasEmitter("prometheus") && ttl > 0 && ttl <= c.Scheduler.longestInterval() {
+				return fmt.Errorf(
+					"prometheus_emitter.series_ttl_seconds must be longer than the longest collection interval (%d seconds)",
+					c.Scheduler.longestInterval(),
+				)
+			}
+			return nil
+		},
+		func() error {
+			return validateCustomQueries(c.CustomQueries)
+		},
+		func() error {
+			return validateIntervalOverrides(c.Scheduler.IntervalOverrides)
+		},
+		func() error {
+			return validateSecrets(c)
+		},
+		func() error {
+			if c.BrokerInfo == "static" {
+				return validateStaticInstances(c.StaticBrokerInfo.Instances)
+			}
+			return nil
+		},
+		func() error {
+			if c.BrokerInfo == "rds" {
+				return validateMonitoringUser(c.RDSBrokerInfo)
+			}
+			return nil
+		},
+		func() error {
+			verifies := c.PostgresCollector.VerifiesServerCertificates() || c.MysqlCollector.VerifiesServerCertificates()
+			if verifies && c.DBCABundleFile == "" {
+				return errors.New("db_ca_bundle_file is required to verify the server certificates")
+			}
+			return nil
+		},
+		func() error {
+			if c.BrokerInfo != "rds" && (c.PostgresCollector.IAMAuth || c.MysqlCollector.IAMAuth) {
+				return errors.New("iam_auth is only supported with the rds broker info")
+			}
+			return nil
+		},
+		func() error {
+			if c.PostgresCollector.IAMAuth && c.PostgresCollector.SSLMode == "disable" {
+				return errors.New("postgres_collector.iam_auth requires postgres_collector.ssl_mode to use TLS")
+			}
+			if c.MysqlCollector.IAMAuth && c.MysqlCollector.TLSMode == "disable" {
+				return errors.New("mysql_collector.iam_auth requires mysql_collector.tls_mode to use TLS")
+			}
+			return nil
+		},
+	}
+}
+
+// ValidateSecrets checks the settings that depend on the values of the
+// secrets. It must be called after ReadSecretFiles, so that the secrets read
+// from files are checked like the inline ones.
+func (c Config) ValidateSecrets() error {
+	if c.BrokerInfo != "rds" {
+		return nil
+	}
+	brokerInfo := c.RDSBrokerInfo
+	u := brokerInfo.MonitoringUser
+	if u.Enabled && u.PasswordSource == "seed" && u.PasswordSeed == brokerInfo.MasterPasswordSeed {
+		return errors.New("rds_broker.monitoring_user.password_seed must be different from rds_broker.master_password_seed")
+	}
+	return nil
+}
+
+func validateMonitoringUser(brokerInfo RDSBrokerInfoConfig) error {
+	u := brokerInfo.MonitoringUser
+	if !u.Enabled {
+		return nil
+	}
+	if u.Username == "" && u.PasswordSource != "secrets_manager" {
+		return errors.New("rds_broker.monitoring_user.username is required")
+	}
+	switch u.PasswordSource {
+	case "seed":
+		if u.PasswordSeed == "" && u.PasswordSeedFile == "" {
+			return errors.New("rds_broker.monitoring_user.password_seed is required with the seed password source")
+		}
+	case "file":
+		if u.PasswordsFile == "" {
+			return errors.New("rds_broker.monitoring_user.passwords_file is required with the file password source")
+		}
+	case "secrets_manager":
+		if u.SecretNamePrefix == "" {
+			return errors.New("rds_broker.monitoring_user.secret_name_prefix is required with the secrets_manager password source")
+		}
+	default:
+		return errors.New("rds_broker.monitoring_user.password_source is required")
+	}
+	return nil
+}
+
+func validateStaticInstances(instances []StaticInstanceConfig) error {
+	guids := map[string]bool{}
+	for _, i := range instances {
+		if guids[i.GUID] {
+			return fmt.Errorf("static_broker instance '%s' is defined more than once", i.GUID)
+		}
+		guids[i.GUID] = true
+	}
+	return nil
+}
 
-	return validate.Struct(c)
+// fieldProblem describes a field that failed validation, by its JSON path
+func fieldProblem(e validator.FieldError) error {
+	path := strings.TrimPrefix(e.Namespace(), "Config.")
+	if e.Param() != "" {
+		return fmt.Errorf("%s: failed the '%s=%s' validation", path, e.Tag(), e.Param())
+	}
+	return fmt.Errorf("%s: failed the '%s' validation", path, e.Tag())
+}
+
+var metricKeyRegexp = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// builtInMetricKeys are the keys of the metrics the collectors send for each
+// engine, which the custom queries cannot use, as their series would be
+// mixed up. They are registered by the collectors, as the config cannot
+// depend on them.
+var builtInMetricKeys = map[string]map[string]bool{}
+
+// RegisterBuiltInMetricKeys makes the validation reject the custom queries of
+// the engine with the given metric keys. It is not safe to call it while the
+// config is validated, so it is meant to be called from init functions.
+func RegisterBuiltInMetricKeys(engine string, keys []string) {
+	if builtInMetricKeys[engine] == nil {
+		builtInMetricKeys[engine] = map[string]bool{}
+	}
+	for _, k := range keys {
+		builtInMetricKeys[engine][k] = true
+	}
+}
+
+func validateCustomQueries(queries []CustomQueryConfig) error {
+	names := map[string]bool{}
+	for _, q := range queries {
+		if names[q.Name] {
+			return fmt.Errorf("custom query '%s' is defined more than once", q.Name)
+		}
+		names[q.Name] = true
+
+		keys := map[string]bool{}
+		for _, m := range q.Metrics {
+			if !metricKeyRegexp.MatchString(m.Key) {
+				return fmt.Errorf("custom query '%s': metric key '%s' must be lower case letters, digits and underscores", q.Name, m.Key)
+			}
+			if keys[m.Key] {
+				return fmt.Errorf("custom query '%s': metric key '%s' is defined more than once", q.Name, m.Key)
+			}
+			if builtInMetricKeys[q.Engine][m.Key] {
+				return fmt.Errorf("custom query '%s': metric key '%s' is already used by a built-in %s metric", q.Name, m.Key, q.Engine)
+			}
+			keys[m.Key] = true
+		}
+
+		for _, c := range q.TagColumns {
+			if keys[c] {
+				return fmt.Errorf("custom query '%s': column '%s' cannot be both a metric and a tag", q.Name, c)
+			}
+		}
+
+		var minVersion, maxVersion utils.Version
+		var err error
+		if q.MinServerVersion != "" {
+			if minVersion, err = utils.ParseVersion(q.MinServerVersion); err != nil {
+				return fmt.Errorf("custom query '%s': %s", q.Name, err)
+			}
+		}
+		if q.MaxServerVersion != "" {
+			if maxVersion, err = utils.ParseVersion(q.MaxServerVersion); err != nil {
+				return fmt.Errorf("custom query '%s': %s", q.Name, err)
+			}
+			if maxVersion.Compare(minVersion) <= 0 {
+				return fmt.Errorf("custom query '%s': max_server_version must be greater than min_server_version", q.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func validateIntervalOverrides(overrides []IntervalOverrideConfig) error {
+	for i, o := range overrides {
+		matches := 0
+		for _, m := range []string{o.InstanceGUID, o.TagKey, o.Engine} {
+			if m != "" {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fmt.Errorf("scheduler.interval_overrides[%d]: exactly one of instance_guid, tag_key or engine must be set", i)
+		}
+		if o.TagValue != "" && o.TagKey == "" {
+			return fmt.Errorf("scheduler.interval_overrides[%d]: tag_value requires tag_key", i)
+		}
+	}
+	return nil
+}
+
+// longestInterval returns the longest collection interval, in seconds,
+// including the overrides
+func (s SchedulerConfig) longestInterval() int {
+	longest := s.SQLMetricCollectorInterval
+	if s.CWMetricCollectorInterval > longest {
+		longest = s.CWMetricCollectorInterval
+	}
+	for _, o := range s.IntervalOverrides {
+		if o.Interval > longest {
+			longest = o.Interval
+		}
+	}
+	return longest
+}
+
+// HasEmitter returns true if the named emitter is enabled
+func (c Config) HasEmitter(name string) bool {
+	for _, e := range c.Emitters {
+		if e == name {
+			return true
+		}
+	}
+	return false
 }
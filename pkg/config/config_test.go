@@ -42,6 +42,19 @@ var _ = Describe("Config", func() {
 			Expect(err).ToNot(HaveOccurred())
 		})
 
+		It("lists every problem of the config", func() {
+			config.LogLevel = ""
+			config.Scheduler.InstanceRefreshInterval = 20000
+			config.Emitters = []string{"prometheus"}
+
+			Expect(config.Problems()).To(ConsistOf(
+				MatchError("log_level: failed the 'required' validation"),
+				MatchError("scheduler.instance_refresh_interval: failed the 'lte=3600' validation"),
+				MatchError(ContainSubstring("prometheus_emitter.listen_address is required")),
+			))
+			Expect(config.Validate()).To(HaveOccurred())
+		})
+
 		It("returns error if LogLevel is not valid", func() {
 			config.LogLevel = ""
 
@@ -55,5 +68,388 @@ var _ = Describe("Config", func() {
 			err := config.Validate()
 			Expect(err).To(HaveOccurred())
 		})
+
+		It("defaults to the loggregator emitter", func() {
+			Expect(config.Emitters).To(Equal([]string{"loggregator"}))
+		})
+
+		It("returns error if an emitter is unknown", func() {
+			config.Emitters = []string{"loggregator", "bananas"}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("returns error if no emitter is enabled", func() {
+			config.Emitters = []string{}
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("returns error if the prometheus emitter has no listen address", func() {
+			config.Emitters = []string{"loggregator", "prometheus"}
+
+			err := config.Validate()
+			Expect(err).To(MatchError(ContainSubstring("listen_address")))
+
+			config.PrometheusEmitter.ListenAddress = "127.0.0.1:9187"
+			err = config.Validate()
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("returns error if the prometheus series TTL is not longer than the collection intervals", func() {
+			config.Emitters = []string{"prometheus"}
+			config.PrometheusEmitter.ListenAddress = "127.0.0.1:9187"
+			config.Scheduler.IntervalOverrides = []IntervalOverrideConfig{
+				{Engine: "postgres", Interval: 600},
+			}
+
+			config.PrometheusEmitter.SeriesTTLSeconds = 600
+			err := config.Validate()
+			Expect(err).To(MatchError(ContainSubstring("series_ttl_seconds must be longer than the longest collection interval (600 seconds)")))
+
+			config.PrometheusEmitter.SeriesTTLSeconds = 1800
+			Expect(config.Validate()).To(Succeed())
+		})
+
+		It("returns error if the operator API listen address is not valid", func() {
+			config.OperatorAPI.ListenAddress = "not an address"
+			Expect(config.Validate()).To(HaveOccurred())
+
+			config.OperatorAPI.ListenAddress = "127.0.0.1:8080"
+			Expect(config.Validate()).ToNot(HaveOccurred())
+		})
+
+		Context("custom queries", func() {
+			var customQuery CustomQueryConfig
+
+			BeforeEach(func() {
+				customQuery = CustomQueryConfig{
+					Name:   "long_running_queries",
+					Engine: "postgres",
+					Type:   "column",
+					Query:  "SELECT count(*) AS long_running_queries, datname FROM pg_stat_activity GROUP BY datname",
+					Metrics: []CustomQueryMetricConfig{
+						{Key: "long_running_queries", Unit: "query"},
+					},
+					TagColumns:       []string{"datname"},
+					MinServerVersion: "10",
+				}
+			})
+
+			It("accepts a valid custom query", func() {
+				config.CustomQueries = []CustomQueryConfig{customQuery}
+
+				err := config.Validate()
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("returns error if the engine or type are unknown", func() {
+				customQuery.Engine = "oracle"
+				config.CustomQueries = []CustomQueryConfig{customQuery}
+				Expect(config.Validate()).To(HaveOccurred())
+
+				customQuery.Engine = "mysql"
+				customQuery.Type = "table"
+				config.CustomQueries = []CustomQueryConfig{customQuery}
+				Expect(config.Validate()).To(HaveOccurred())
+			})
+
+			It("returns error if the query has no SQL or no metrics", func() {
+				noSQL := customQuery
+				noSQL.Query = ""
+				config.CustomQueries = []CustomQueryConfig{noSQL}
+				Expect(config.Validate()).To(HaveOccurred())
+
+				noMetrics := customQuery
+				noMetrics.Metrics = nil
+				config.CustomQueries = []CustomQueryConfig{noMetrics}
+				Expect(config.Validate()).To(HaveOccurred())
+			})
+
+			It("returns error if a metric is malformed", func() {
+				customQuery.Metrics = []CustomQueryMetricConfig{{Key: "Long Running", Unit: "query"}}
+				config.CustomQueries = []CustomQueryConfig{customQuery}
+				Expect(config.Validate()).To(MatchError(ContainSubstring("metric key 'Long Running'")))
+
+				customQuery.Metrics = []CustomQueryMetricConfig{{Key: "long_running_queries", Unit: "query", Kind: "histogram"}}
+				config.CustomQueries = []CustomQueryConfig{customQuery}
+				Expect(config.Validate()).To(HaveOccurred())
+			})
+
+			It("returns error if a column is both a metric and a tag", func() {
+				customQuery.TagColumns = []string{"long_running_queries"}
+				config.CustomQueries = []CustomQueryConfig{customQuery}
+				Expect(config.Validate()).To(MatchError(ContainSubstring("both a metric and a tag")))
+			})
+
+			It("returns error if a metric key is used by a built-in metric of the engine", func() {
+				RegisterBuiltInMetricKeys("postgres", []string{"connections"})
+
+				customQuery.Metrics = []CustomQueryMetricConfig{{Key: "connections", Unit: "conn"}}
+				config.CustomQueries = []CustomQueryConfig{customQuery}
+				Expect(config.Validate()).To(MatchError(ContainSubstring(
+					"metric key 'connections' is already used by a built-in postgres metric",
+				)))
+
+				customQuery.Engine = "mysql"
+				config.CustomQueries = []CustomQueryConfig{customQuery}
+				Expect(config.Validate()).ToNot(HaveOccurred())
+			})
+
+			It("returns error if two queries have the same name", func() {
+				config.CustomQueries = []CustomQueryConfig{customQuery, customQuery}
+				Expect(config.Validate()).To(MatchError(ContainSubstring("more than once")))
+			})
+
+			It("returns error if the minimum server version cannot be parsed", func() {
+				customQuery.MinServerVersion = "latest"
+				config.CustomQueries = []CustomQueryConfig{customQuery}
+				Expect(config.Validate()).To(MatchError(ContainSubstring("cannot parse version")))
+			})
+
+			It("returns error if the server version range is empty", func() {
+				customQuery.MaxServerVersion = "10"
+				config.CustomQueries = []CustomQueryConfig{customQuery}
+				Expect(config.Validate()).To(MatchError(ContainSubstring("max_server_version must be greater")))
+
+				customQuery.MaxServerVersion = "13"
+				config.CustomQueries = []CustomQueryConfig{customQuery}
+				Expect(config.Validate()).ToNot(HaveOccurred())
+			})
+		})
+
+		Context("static broker info", func() {
+			BeforeEach(func() {
+				var err error
+				config, err = LoadConfig("../../fixtures/static_collector_config.json")
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("does not need the RDS broker info nor the AWS region", func() {
+				Expect(config.BrokerInfo).To(Equal("static"))
+				Expect(config.RDSBrokerInfo).To(Equal(RDSBrokerInfoConfig{}))
+				Expect(config.AWS.Region).To(BeEmpty())
+				Expect(config.StaticBrokerInfo.Instances).To(HaveLen(2))
+				Expect(config.Validate()).ToNot(HaveOccurred())
+			})
+
+			It("returns error if an instance is not valid", func() {
+				config.StaticBrokerInfo.Instances[0].Engine = "oracle"
+				Expect(config.Validate()).To(HaveOccurred())
+
+				config.StaticBrokerInfo.Instances[0].Engine = "postgres"
+				config.StaticBrokerInfo.Instances[0].Port = 0
+				Expect(config.Validate()).To(HaveOccurred())
+			})
+
+			It("returns error if there are no instances", func() {
+				config.StaticBrokerInfo.Instances = nil
+				Expect(config.Validate()).To(HaveOccurred())
+			})
+
+			It("returns error if two instances have the same GUID", func() {
+				config.StaticBrokerInfo.Instances[1].GUID = config.StaticBrokerInfo.Instances[0].GUID
+				Expect(config.Validate()).To(MatchError(ContainSubstring("defined more than once")))
+			})
+
+			It("returns error if a password and its file are both set", func() {
+				config.StaticBrokerInfo.Instances[0].PasswordFile = "/run/secrets/password"
+				Expect(config.Validate()).To(MatchError(
+					"static_broker.instances[0].password_file cannot be set together with static_broker.instances[0].password",
+				))
+			})
+
+			It("returns error if IAM auth is enabled", func() {
+				config.MysqlCollector.IAMAuth = true
+				Expect(config.Validate()).To(MatchError(ContainSubstring("iam_auth is only supported with the rds broker info")))
+			})
+
+			It("ignores the static instances when using the RDS broker info", func() {
+				config.BrokerInfo = "rds"
+				config.StaticBrokerInfo.Instances[0].Engine = "oracle"
+				Expect(config.Validate()).To(HaveOccurred())
+
+				config.AWS.Region = "eu-west-1"
+				config.RDSBrokerInfo = RDSBrokerInfoConfig{
+					BrokerName:         "mybroker",
+					DBPrefix:           "build-test",
+					MasterPasswordSeed: "something-secret",
+				}
+				Expect(config.Validate()).ToNot(HaveOccurred())
+			})
+		})
+
+		Context("metric tags", func() {
+			It("accepts the instance metadata and tags", func() {
+				config.MetricTags = MetricTagsConfig{
+					InstanceMetadata: []string{"engine", "engine_version", "instance_class", "multi_az"},
+					InstanceTags:     []string{"Plan ID"},
+				}
+				Expect(config.Validate()).ToNot(HaveOccurred())
+			})
+
+			It("returns error if the instance metadata is unknown", func() {
+				config.MetricTags.InstanceMetadata = []string{"engine", "storage"}
+				Expect(config.Problems()).To(ConsistOf(
+					MatchError("metric_tags.instance_metadata[1]: failed the 'oneof=engine engine_version instance_class multi_az' validation"),
+				))
+			})
+
+			It("returns error if an instance tag is empty", func() {
+				config.MetricTags.InstanceTags = []string{""}
+				Expect(config.Validate()).To(HaveOccurred())
+			})
+		})
+
+		Context("database TLS", func() {
+			It("requires TLS without verifying the server certificates by default", func() {
+				Expect(config.PostgresCollector.SSLMode).To(Equal("require"))
+				Expect(config.MysqlCollector.TLSMode).To(Equal("skip-verify"))
+			})
+
+			It("returns error if a mode is not valid", func() {
+				config.PostgresCollector.SSLMode = "prefer"
+				Expect(config.Validate()).To(HaveOccurred())
+
+				config.PostgresCollector.SSLMode = "require"
+				config.MysqlCollector.TLSMode = "preferred"
+				Expect(config.Validate()).To(HaveOccurred())
+			})
+
+			It("requires the CA bundle to verify the server certificates", func() {
+				config.PostgresCollector.SSLMode = "verify-full"
+				Expect(config.Validate()).To(MatchError(ContainSubstring("db_ca_bundle_file is required")))
+
+				config.PostgresCollector.SSLMode = "require"
+				config.MysqlCollector.TLSMode = "verify-full"
+				Expect(config.Validate()).To(MatchError(ContainSubstring("db_ca_bundle_file is required")))
+
+				config.DBCABundleFile = "/etc/ssl/rds-ca-bundle.pem"
+				Expect(config.Validate()).ToNot(HaveOccurred())
+			})
+
+			It("requires TLS with IAM auth", func() {
+				config.PostgresCollector.IAMAuth = true
+				config.PostgresCollector.SSLMode = "disable"
+				Expect(config.Validate()).To(MatchError(ContainSubstring("postgres_collector.iam_auth requires postgres_collector.ssl_mode to use TLS")))
+
+				config.PostgresCollector.SSLMode = "require"
+				Expect(config.Validate()).ToNot(HaveOccurred())
+
+				config.MysqlCollector.IAMAuth = true
+				config.MysqlCollector.TLSMode = "disable"
+				Expect(config.Validate()).To(MatchError(ContainSubstring("mysql_collector.iam_auth requires mysql_collector.tls_mode to use TLS")))
+
+				config.MysqlCollector.TLSMode = "skip-verify"
+				Expect(config.Validate()).ToNot(HaveOccurred())
+			})
+		})
+
+		Context("monitoring user", func() {
+			BeforeEach(func() {
+				config.RDSBrokerInfo.MonitoringUser = MonitoringUserConfig{
+					Enabled:        true,
+					Username:       "monitoring",
+					PasswordSource: "seed",
+					PasswordSeed:   "monitoring-seed",
+				}
+			})
+
+			It("accepts a monitoring user with a password seed", func() {
+				Expect(config.Validate()).ToNot(HaveOccurred())
+			})
+
+			It("returns error if the username is missing", func() {
+				config.RDSBrokerInfo.MonitoringUser.Username = ""
+				Expect(config.Validate()).To(MatchError(ContainSubstring("monitoring_user.username is required")))
+			})
+
+			It("does not need the username with the secrets_manager password source", func() {
+				config.RDSBrokerInfo.MonitoringUser.Username = ""
+				config.RDSBrokerInfo.MonitoringUser.PasswordSource = "secrets_manager"
+				config.RDSBrokerInfo.MonitoringUser.SecretNamePrefix = "rds-monitoring/"
+				Expect(config.Validate()).ToNot(HaveOccurred())
+			})
+
+			It("returns error if the password source is not valid", func() {
+				config.RDSBrokerInfo.MonitoringUser.PasswordSource = "vault"
+				Expect(config.Validate()).To(HaveOccurred())
+
+				config.RDSBrokerInfo.MonitoringUser.PasswordSource = ""
+				Expect(config.Validate()).To(MatchError(ContainSubstring("monitoring_user.password_source is required")))
+			})
+
+			It("returns error if the password seed is the master password seed", func() {
+				config.RDSBrokerInfo.MonitoringUser.PasswordSeed = config.RDSBrokerInfo.MasterPasswordSeed
+				Expect(config.ValidateSecrets()).To(MatchError(ContainSubstring("must be different from rds_broker.master_password_seed")))
+			})
+
+			It("returns error if the setting of the password source is missing", func() {
+				config.RDSBrokerInfo.MonitoringUser.PasswordSeed = ""
+				Expect(config.Validate()).To(MatchError(ContainSubstring("password_seed is required")))
+
+				config.RDSBrokerInfo.MonitoringUser.PasswordSource = "file"
+				Expect(config.Validate()).To(MatchError(ContainSubstring("passwords_file is required")))
+
+				config.RDSBrokerInfo.MonitoringUser.PasswordSource = "secrets_manager"
+				Expect(config.Validate()).To(MatchError(ContainSubstring("secret_name_prefix is required")))
+			})
+
+			It("ignores the monitoring user if it is not enabled", func() {
+				config.RDSBrokerInfo.MonitoringUser = MonitoringUserConfig{Username: "monitoring"}
+				Expect(config.Validate()).ToNot(HaveOccurred())
+			})
+		})
+
+		Context("interval overrides", func() {
+			It("accepts overrides by instance, tag and engine", func() {
+				config.Scheduler.IntervalOverrides = []IntervalOverrideConfig{
+					{InstanceGUID: "instance-1", Interval: 600},
+					{TagKey: "Plan ID", TagValue: "plan-1", Driver: "cloudwatch", Interval: 60},
+					{Engine: "mysql", Interval: 30},
+				}
+				Expect(config.Validate()).ToNot(HaveOccurred())
+			})
+
+			It("returns error if the interval is not valid", func() {
+				config.Scheduler.IntervalOverrides = []IntervalOverrideConfig{
+					{InstanceGUID: "instance-1", Interval: 0},
+				}
+				Expect(config.Validate()).To(HaveOccurred())
+			})
+
+			It("returns error if the engine or driver are unknown", func() {
+				config.Scheduler.IntervalOverrides = []IntervalOverrideConfig{
+					{Engine: "oracle", Interval: 60},
+				}
+				Expect(config.Validate()).To(HaveOccurred())
+
+				config.Scheduler.IntervalOverrides = []IntervalOverrideConfig{
+					{Engine: "mysql", Driver: "sql", Interval: 60},
+				}
+				Expect(config.Validate()).To(HaveOccurred())
+			})
+
+			It("returns error unless exactly one match is set", func() {
+				config.Scheduler.IntervalOverrides = []IntervalOverrideConfig{
+					{Interval: 60},
+				}
+				Expect(config.Validate()).To(MatchError(ContainSubstring("exactly one of")))
+
+				config.Scheduler.IntervalOverrides = []IntervalOverrideConfig{
+					{InstanceGUID: "instance-1", Engine: "mysql", Interval: 60},
+				}
+				Expect(config.Validate()).To(MatchError(ContainSubstring("exactly one of")))
+			})
+
+			It("returns error if a tag value has no tag key", func() {
+				config.Scheduler.IntervalOverrides = []IntervalOverrideConfig{
+					{Engine: "mysql", TagValue: "plan-1", Interval: 60},
+				}
+				Expect(config.Validate()).To(MatchError(ContainSubstring("tag_value requires tag_key")))
+			})
+		})
 	})
 })
@@ -0,0 +1,195 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+)
+
+// EnvPrefix is the prefix of the environment variables that override the
+// config fields
+const EnvPrefix = "RDS_METRIC_COLLECTOR_"
+
+// secretFileTag marks the fields with the path of a file holding a secret.
+// Its value is the name of the field the secret is read into.
+const secretFileTag = "secret_file"
+
+// configField is a field of the config that is not a struct, with the path of
+// JSON keys to it. inSlice is true for the fields of the structs in a slice,
+// like the fields of every custom query.
+type configField struct {
+	value   reflect.Value
+	parent  reflect.Value
+	field   reflect.StructField
+	path    []string
+	inSlice bool
+}
+
+func (f configField) jsonPath() string {
+	return strings.Join(f.path, ".")
+}
+
+func (f configField) envName() string {
+	return EnvPrefix + strings.ToUpper(strings.Join(f.path, "_"))
+}
+
+// siblingEnvName returns the name of the environment variable of another
+// field of the same struct
+func (f configField) siblingEnvName(sibling reflect.StructField) string {
+	path := append(append([]string{}, f.path[:len(f.path)-1]...), jsonName(sibling))
+	return EnvPrefix + strings.ToUpper(strings.Join(path, "_"))
+}
+
+// secretPair returns the field paired with a secret or with its file, if any
+func (f configField) secretPair() (reflect.StructField, bool) {
+	if name := f.field.Tag.Get(secretFileTag); name != "" {
+		return f.parent.Type().FieldByName(name)
+	}
+	t := f.parent.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get(secretFileTag) == f.field.Name {
+			return t.Field(i), true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// walkFields calls fn for every field of the config that is not a struct.
+// Embedded structs are walked as if their fields belonged to the parent, and
+// the structs in slices are walked after the slice itself.
+func walkFields(v reflect.Value, path []string, fn func(f configField) error) error {
+	return walk(v, path, false, fn)
+}
+
+func walk(v reflect.Value, path []string, inSlice bool, fn func(f configField) error) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if err := walk(v.Field(i), path, inSlice, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		name := jsonName(field)
+		if name == "" || name == "-" {
+			continue
+		}
+
+		fieldPath := append(append([]string{}, path...), name)
+		if field.Type.Kind() == reflect.Struct {
+			if err := walk(v.Field(i), fieldPath, inSlice, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		f := configField{value: v.Field(i), parent: v, field: field, path: fieldPath, inSlice: inSlice}
+		if err := fn(f); err != nil {
+			return err
+		}
+
+		if field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct {
+			for j := 0; j < f.value.Len(); j++ {
+				elemPath := append(append([]string{}, path...), fmt.Sprintf("%s[%d]", name, j))
+				if err := walk(f.value.Index(j), elemPath, true, fn); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ApplyEnv overrides the fields of the config with the environment variables
+// named after their JSON path, e.g. RDS_METRIC_COLLECTOR_SCHEDULER_INSTANCE_REFRESH_INTERVAL
+// for scheduler.instance_refresh_interval. String fields take the value as
+// it is, and other fields parse it as JSON. Lists are overridden as a whole.
+//
+// Setting a secret or its file clears the other one, so that a secret in the
+// environment replaces a secret file in the config file, and the other way
+// round. If both are set in the environment, neither is cleared, so that the
+// config is rejected by Validate like when both are set in the config file.
+func (c *Config) ApplyEnv(environ []string) error {
+	env := map[string]string{}
+	for _, kv := range environ {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 && strings.HasPrefix(parts[0], EnvPrefix) {
+			env[parts[0]] = parts[1]
+		}
+	}
+
+	return walkFields(reflect.ValueOf(c).Elem(), nil, func(f configField) error {
+		if f.inSlice {
+			return nil
+		}
+		value, ok := env[f.envName()]
+		if !ok {
+			return nil
+		}
+
+		if f.value.Kind() == reflect.String {
+			f.value.SetString(value)
+		} else if err := json.Unmarshal([]byte(value), f.value.Addr().Interface()); err != nil {
+			return fmt.Errorf("parsing %s: %s", f.envName(), err)
+		}
+
+		if pair, ok := f.secretPair(); ok {
+			if _, pairInEnv := env[f.siblingEnvName(pair)]; !pairInEnv {
+				f.parent.FieldByIndex(pair.Index).SetString("")
+			}
+		}
+		return nil
+	})
+}
+
+// ReadSecretFiles reads the secrets from their files, and clears the paths
+// of the files so that the config still validates
+func (c *Config) ReadSecretFiles() error {
+	return walkFields(reflect.ValueOf(c).Elem(), nil, func(f configField) error {
+		if f.field.Tag.Get(secretFileTag) == "" || f.value.String() == "" {
+			return nil
+		}
+		pair, _ := f.secretPair()
+
+		contents, err := ioutil.ReadFile(f.value.String())
+		if err != nil {
+			return fmt.Errorf("reading %s: %s", f.jsonPath(), err)
+		}
+		secret := strings.TrimRight(string(contents), "\r\n")
+		if secret == "" {
+			return fmt.Errorf("reading %s: %s is empty", f.jsonPath(), f.value.String())
+		}
+
+		f.parent.FieldByIndex(pair.Index).SetString(secret)
+		f.value.SetString("")
+		return nil
+	})
+}
+
+// validateSecrets checks that a secret and its file are not both set by the
+// same source, as it would be ambiguous which one is used
+func validateSecrets(c Config) error {
+	return walkFields(reflect.ValueOf(&c).Elem(), nil, func(f configField) error {
+		if f.field.Tag.Get(secretFileTag) == "" || f.value.String() == "" {
+			return nil
+		}
+		pair, _ := f.secretPair()
+		if f.parent.FieldByIndex(pair.Index).String() != "" {
+			return fmt.Errorf(
+				"%s cannot be set together with %s",
+				f.jsonPath(),
+				strings.Join(append(f.path[:len(f.path)-1:len(f.path)-1], jsonName(pair)), "."),
+			)
+		}
+		return nil
+	})
+}
+
+func jsonName(field reflect.StructField) string {
+	return strings.Split(field.Tag.Get("json"), ",")[0]
+}
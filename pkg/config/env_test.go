@@ -0,0 +1,191 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Environment overrides", func() {
+	var (
+		config *Config
+	)
+
+	BeforeEach(func() {
+		var err error
+		config, err = LoadConfig("../../fixtures/collector_config.json")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	Describe("ApplyEnv", func() {
+		It("overrides the fields named after their JSON path", func() {
+			err := config.ApplyEnv([]string{
+				"RDS_METRIC_COLLECTOR_LOG_LEVEL=DEBUG",
+				"RDS_METRIC_COLLECTOR_RDS_BROKER_DB_PREFIX=other-prefix",
+				"RDS_METRIC_COLLECTOR_SCHEDULER_INSTANCE_REFRESH_INTERVAL=60",
+				"RDS_METRIC_COLLECTOR_SCHEDULER_COLLECTOR_MAX_RETRIES=5",
+				"RDS_METRIC_COLLECTOR_EMITTERS=[\"stdout\",\"prometheus\"]",
+				"RDS_METRIC_COLLECTOR_LOCKET_ADDRESS=locket.example.com:8891",
+				"OTHER_LOG_LEVEL=ERROR",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(config.LogLevel).To(Equal("DEBUG"))
+			Expect(config.RDSBrokerInfo.DBPrefix).To(Equal("other-prefix"))
+			Expect(config.RDSBrokerInfo.BrokerName).To(Equal("mybroker"))
+			Expect(config.Scheduler.InstanceRefreshInterval).To(Equal(60))
+			Expect(*config.Scheduler.CollectorMaxRetries).To(Equal(5))
+			Expect(config.Emitters).To(Equal([]string{"stdout", "prometheus"}))
+			Expect(config.LocketAddress).To(Equal("locket.example.com:8891"))
+		})
+
+		It("returns error if a value cannot be parsed", func() {
+			err := config.ApplyEnv([]string{
+				"RDS_METRIC_COLLECTOR_SCHEDULER_INSTANCE_REFRESH_INTERVAL=soon",
+			})
+			Expect(err).To(MatchError(ContainSubstring("RDS_METRIC_COLLECTOR_SCHEDULER_INSTANCE_REFRESH_INTERVAL")))
+		})
+
+		It("replaces a secret from the config file with a secret file", func() {
+			err := config.ApplyEnv([]string{
+				"RDS_METRIC_COLLECTOR_RDS_BROKER_MASTER_PASSWORD_SEED_FILE=/run/secrets/seed",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(config.RDSBrokerInfo.MasterPasswordSeed).To(BeEmpty())
+			Expect(config.RDSBrokerInfo.MasterPasswordSeedFile).To(Equal("/run/secrets/seed"))
+			Expect(config.Validate()).ToNot(HaveOccurred())
+		})
+
+		It("replaces a secret file from the config file with a secret", func() {
+			config.RDSBrokerInfo.MasterPasswordSeed = ""
+			config.RDSBrokerInfo.MasterPasswordSeedFile = "/run/secrets/seed"
+
+			err := config.ApplyEnv([]string{
+				"RDS_METRIC_COLLECTOR_RDS_BROKER_MASTER_PASSWORD_SEED=from-env",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(config.RDSBrokerInfo.MasterPasswordSeed).To(Equal("from-env"))
+			Expect(config.RDSBrokerInfo.MasterPasswordSeedFile).To(BeEmpty())
+		})
+
+		It("keeps both a secret and its file set in the environment, so that they are rejected", func() {
+			err := config.ApplyEnv([]string{
+				"RDS_METRIC_COLLECTOR_RDS_BROKER_MASTER_PASSWORD_SEED=from-env",
+				"RDS_METRIC_COLLECTOR_RDS_BROKER_MASTER_PASSWORD_SEED_FILE=/run/secrets/seed",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(config.RDSBrokerInfo.MasterPasswordSeed).To(Equal("from-env"))
+			Expect(config.RDSBrokerInfo.MasterPasswordSeedFile).To(Equal("/run/secrets/seed"))
+			Expect(config.Validate()).To(MatchError(
+				"rds_broker.master_password_seed_file cannot be set together with rds_broker.master_password_seed",
+			))
+		})
+	})
+
+	Describe("Validate", func() {
+		It("returns error if a secret and its file are both set", func() {
+			config.RDSBrokerInfo.MasterPasswordSeedFile = "/run/secrets/seed"
+			Expect(config.Validate()).To(MatchError(
+				"rds_broker.master_password_seed_file cannot be set together with rds_broker.master_password_seed",
+			))
+		})
+
+		It("returns error if neither a secret nor its file are set", func() {
+			config.RDSBrokerInfo.MasterPasswordSeed = ""
+			Expect(config.Validate()).To(HaveOccurred())
+		})
+	})
+
+	Describe("LoadConfig", func() {
+		var secretFile string
+
+		BeforeEach(func() {
+			secretFile = filepath.Join(GinkgoT().TempDir(), "seed")
+			Expect(os.WriteFile(secretFile, []byte("seed-from-file\n"), 0600)).To(Succeed())
+		})
+
+		setenv := func(name, value string) {
+			Expect(os.Setenv(name, value)).To(Succeed())
+			DeferCleanup(os.Unsetenv, name)
+		}
+
+		It("applies the environment over the config file", func() {
+			setenv("RDS_METRIC_COLLECTOR_RDS_BROKER_BROKER_NAME", "otherbroker")
+
+			config, err := LoadConfig("../../fixtures/collector_config.json")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.RDSBrokerInfo.BrokerName).To(Equal("otherbroker"))
+		})
+
+		It("reads the secrets from their files", func() {
+			setenv("RDS_METRIC_COLLECTOR_RDS_BROKER_MASTER_PASSWORD_SEED_FILE", secretFile)
+
+			config, err := LoadConfig("../../fixtures/collector_config.json")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.RDSBrokerInfo.MasterPasswordSeed).To(Equal("seed-from-file"))
+			Expect(config.RDSBrokerInfo.MasterPasswordSeedFile).To(BeEmpty())
+			Expect(config.Validate()).ToNot(HaveOccurred())
+		})
+
+		It("returns error if the monitoring user seed read from a file is the master password seed", func() {
+			setenv("RDS_METRIC_COLLECTOR_RDS_BROKER_MONITORING_USER_ENABLED", "true")
+			setenv("RDS_METRIC_COLLECTOR_RDS_BROKER_MONITORING_USER_USERNAME", "monitoring")
+			setenv("RDS_METRIC_COLLECTOR_RDS_BROKER_MONITORING_USER_PASSWORD_SOURCE", "seed")
+			setenv("RDS_METRIC_COLLECTOR_RDS_BROKER_MONITORING_USER_PASSWORD_SEED_FILE", secretFile)
+			setenv("RDS_METRIC_COLLECTOR_RDS_BROKER_MASTER_PASSWORD_SEED", "seed-from-file")
+
+			_, err := LoadConfig("../../fixtures/collector_config.json")
+			Expect(err).To(MatchError(ContainSubstring("must be different from rds_broker.master_password_seed")))
+		})
+
+		It("reads the secrets of the items of a list from their files", func() {
+			configFile := filepath.Join(GinkgoT().TempDir(), "config.json")
+			Expect(os.WriteFile(configFile, []byte(`{
+				"log_level": "INFO",
+				"broker_info": "static",
+				"static_broker": {
+					"instances": [{
+						"guid": "local-postgres",
+						"engine": "postgres",
+						"address": "localhost",
+						"port": 5432,
+						"db_name": "postgres",
+						"username": "postgres",
+						"password_file": "`+secretFile+`"
+					}]
+				},
+				"scheduler": {
+					"instance_refresh_interval": 30,
+					"sql_metrics_collector_interval": 5,
+					"cloudwatch_metrics_collector_interval": 5
+				},
+				"emitters": ["stdout"]
+			}`), 0600)).To(Succeed())
+
+			config, err := LoadConfig(configFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.StaticBrokerInfo.Instances[0].Password).To(Equal("seed-from-file"))
+			Expect(config.StaticBrokerInfo.Instances[0].PasswordFile).To(BeEmpty())
+		})
+
+		It("returns error if a secret file cannot be read", func() {
+			setenv("RDS_METRIC_COLLECTOR_RDS_BROKER_MASTER_PASSWORD_SEED_FILE", secretFile+".missing")
+
+			_, err := LoadConfig("../../fixtures/collector_config.json")
+			Expect(err).To(MatchError(ContainSubstring("reading rds_broker.master_password_seed_file")))
+		})
+
+		It("returns error if a secret file is empty", func() {
+			Expect(os.WriteFile(secretFile, []byte("\n"), 0600)).To(Succeed())
+			setenv("RDS_METRIC_COLLECTOR_RDS_BROKER_MASTER_PASSWORD_SEED_FILE", secretFile)
+
+			_, err := LoadConfig("../../fixtures/collector_config.json")
+			Expect(err).To(MatchError(ContainSubstring("is empty")))
+		})
+	})
+})
@@ -4,4 +4,6 @@ import "github.com/alphagov/paas-rds-metric-collector/pkg/metrics"
 
 type MetricsEmitter interface {
 	Emit(metrics.MetricEnvelope)
+	// EmitBatch emits all the envelopes produced by one collection
+	EmitBatch([]metrics.MetricEnvelope)
 }
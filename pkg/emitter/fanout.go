@@ -0,0 +1,173 @@
+package emitter
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager/v3"
+
+	"github.com/alphagov/paas-rds-metric-collector/pkg/metrics"
+)
+
+const defaultFanOutQueueSize = 1024
+
+// deliveryFailureLogInterval is the minimum time between two logs of the
+// delivery failures of a sink, so that a failing sink does not flood the logs
+const deliveryFailureLogInterval = time.Minute
+
+// FanOutSink is one of the emitters a FanOutEmitter delivers to
+type FanOutSink struct {
+	Name    string
+	Emitter MetricsEmitter
+}
+
+// FanOutEmitter sends every envelope to several emitters. Each sink gets its
+// own queue of batches and goroutine, so a slow or panicking sink does not
+// block or break the others. Envelopes that cannot be delivered to a sink are
+// dropped and counted as delivery failures for that sink.
+//
+// The sinks are only fed while the emitter is running as an ifrit.Runner.
+type FanOutEmitter struct {
+	sinks  []*fanOutSinkWorker
+	clock  clock.Clock
+	logger lager.Logger
+}
+
+type fanOutSinkWorker struct {
+	name    string
+	emitter MetricsEmitter
+	queue   chan []metrics.MetricEnvelope
+
+	mutex          sync.Mutex
+	failures       uint64
+	unloggedDrops  uint64
+	lastFailureLog time.Time
+}
+
+// NewFanOutEmitter ...
+func NewFanOutEmitter(sinks []FanOutSink, queueSize int, clock clock.Clock, logger lager.Logger) *FanOutEmitter {
+	if queueSize <= 0 {
+		queueSize = defaultFanOutQueueSize
+	}
+
+	workers := []*fanOutSinkWorker{}
+	for _, sink := range sinks {
+		workers = append(workers, &fanOutSinkWorker{
+			name:    sink.Name,
+			emitter: sink.Emitter,
+			queue:   make(chan []metrics.MetricEnvelope, queueSize),
+		})
+	}
+
+	return &FanOutEmitter{
+		sinks:  workers,
+		clock:  clock,
+		logger: logger,
+	}
+}
+
+// Emit queues the envelope for every sink without blocking
+func (e *FanOutEmitter) Emit(me metrics.MetricEnvelope) {
+	e.EmitBatch([]metrics.MetricEnvelope{me})
+}
+
+// EmitBatch queues the envelopes for every sink without blocking
+func (e *FanOutEmitter) EmitBatch(envelopes []metrics.MetricEnvelope) {
+	for _, sink := range e.sinks {
+		select {
+		case sink.queue <- envelopes:
+		default:
+			e.recordFailures(sink, len(envelopes), fmt.Errorf("queue full"))
+		}
+	}
+}
+
+// DeliveryFailures returns the number of envelopes each sink failed to deliver
+func (e *FanOutEmitter) DeliveryFailures() map[string]uint64 {
+	failures := map[string]uint64{}
+	for _, sink := range e.sinks {
+		failures[sink.name] = sink.deliveryFailures()
+	}
+	return failures
+}
+
+// Run delivers the queued envelopes to the sinks until signalled
+func (e *FanOutEmitter) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for _, sink := range e.sinks {
+		wg.Add(1)
+		go func(sink *fanOutSinkWorker) {
+			defer wg.Done()
+			e.deliver(sink, done)
+		}(sink)
+	}
+
+	close(ready)
+	<-signals
+	close(done)
+	wg.Wait()
+
+	return nil
+}
+
+func (e *FanOutEmitter) deliver(sink *fanOutSinkWorker, done <-chan struct{}) {
+	for {
+		select {
+		case envelopes := <-sink.queue:
+			e.emitToSink(sink, envelopes)
+		case <-done:
+			return
+		}
+	}
+}
+
+func (e *FanOutEmitter) emitToSink(sink *fanOutSinkWorker, envelopes []metrics.MetricEnvelope) {
+	defer func() {
+		if r := recover(); r != nil {
+			e.recordFailures(sink, len(envelopes), fmt.Errorf("sink panicked: %v", r))
+		}
+	}()
+	sink.emitter.EmitBatch(envelopes)
+}
+
+// recordFailures counts the envelopes dropped for the sink, and logs them at
+// most once per deliveryFailureLogInterval. The drops not logged yet are
+// included in the next log.
+func (e *FanOutEmitter) recordFailures(sink *fanOutSinkWorker, count int, err error) {
+	dropped, failures, shouldLog := sink.recordFailures(count, e.clock.Now())
+	if !shouldLog {
+		return
+	}
+	e.logger.Error("delivery_failed", err, lager.Data{
+		"sink":     sink.name,
+		"dropped":  dropped,
+		"failures": failures,
+	})
+}
+
+// recordFailures returns the drops to log, if it is time to log them, and
+// the total of failures
+func (s *fanOutSinkWorker) recordFailures(count int, now time.Time) (uint64, uint64, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.failures += uint64(count)
+	s.unloggedDrops += uint64(count)
+
+	if !s.lastFailureLog.IsZero() && now.Sub(s.lastFailureLog) < deliveryFailureLogInterval {
+		return 0, s.failures, false
+	}
+	dropped := s.unloggedDrops
+	s.unloggedDrops = 0
+	s.lastFailureLog = now
+	return dropped, s.failures, true
+}
+
+func (s *fanOutSinkWorker) deliveryFailures() uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.failures
+}
@@ -0,0 +1,177 @@
+package emitter_test
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	"github.com/tedsuo/ifrit"
+
+	"github.com/alphagov/paas-rds-metric-collector/pkg/emitter"
+	"github.com/alphagov/paas-rds-metric-collector/pkg/metrics"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type recordingEmitter struct {
+	mutex     sync.Mutex
+	envelopes []metrics.MetricEnvelope
+	block     chan struct{}
+	panics    bool
+}
+
+func (r *recordingEmitter) Emit(me metrics.MetricEnvelope) {
+	if r.block != nil {
+		<-r.block
+	}
+	if r.panics {
+		panic("sink is broken")
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.envelopes = append(r.envelopes, me)
+}
+
+func (r *recordingEmitter) EmitBatch(envelopes []metrics.MetricEnvelope) {
+	for _, me := range envelopes {
+		r.Emit(me)
+	}
+}
+
+func (r *recordingEmitter) received() []metrics.MetricEnvelope {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]metrics.MetricEnvelope{}, r.envelopes...)
+}
+
+var _ = Describe("FanOutEmitter", func() {
+	var (
+		sink1         *recordingEmitter
+		sink2         *recordingEmitter
+		fakeClock     *fakeclock.FakeClock
+		testLogger    *lagertest.TestLogger
+		fanOutEmitter *emitter.FanOutEmitter
+		process       ifrit.Process
+		envelope      metrics.MetricEnvelope
+	)
+
+	BeforeEach(func() {
+		sink1 = &recordingEmitter{}
+		sink2 = &recordingEmitter{}
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		testLogger = lagertest.NewTestLogger("fanout")
+		envelope = metrics.MetricEnvelope{
+			InstanceGUID: "instance-guid",
+			Metric:       metrics.Metric{Key: "a_key", Value: 1, Unit: "bytes"},
+		}
+	})
+
+	JustBeforeEach(func() {
+		fanOutEmitter = emitter.NewFanOutEmitter(
+			[]emitter.FanOutSink{
+				{Name: "sink1", Emitter: sink1},
+				{Name: "sink2", Emitter: sink2},
+			},
+			2,
+			fakeClock,
+			testLogger,
+		)
+		process = ifrit.Invoke(fanOutEmitter)
+	})
+
+	AfterEach(func() {
+		if sink1.block != nil {
+			close(sink1.block)
+		}
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive())
+	})
+
+	It("should send every envelope to all the sinks", func() {
+		fanOutEmitter.Emit(envelope)
+
+		Eventually(sink1.received).Should(ConsistOf(envelope))
+		Eventually(sink2.received).Should(ConsistOf(envelope))
+		Expect(fanOutEmitter.DeliveryFailures()).To(Equal(map[string]uint64{"sink1": 0, "sink2": 0}))
+	})
+
+	It("should send every batch to all the sinks", func() {
+		fanOutEmitter.EmitBatch([]metrics.MetricEnvelope{envelope, envelope})
+
+		Eventually(sink1.received).Should(HaveLen(2))
+		Eventually(sink2.received).Should(HaveLen(2))
+	})
+
+	Context("when one sink is slow", func() {
+		BeforeEach(func() {
+			sink1.block = make(chan struct{})
+		})
+
+		It("should not block the other sinks and count the dropped envelopes", func() {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 10; i++ {
+					fanOutEmitter.Emit(envelope)
+				}
+			}()
+			Eventually(done, 1*time.Second).Should(BeClosed())
+
+			Eventually(func() int { return len(sink2.received()) }).Should(BeNumerically(">=", 2))
+			Expect(sink1.received()).To(BeEmpty())
+			Expect(fanOutEmitter.DeliveryFailures()["sink1"]).To(BeNumerically(">", 0))
+		})
+	})
+
+	Context("when one sink panics", func() {
+		BeforeEach(func() {
+			sink1.panics = true
+		})
+
+		It("should keep delivering to the other sinks and count the failures", func() {
+			fanOutEmitter.Emit(envelope)
+			fanOutEmitter.Emit(envelope)
+
+			Eventually(sink2.received).Should(HaveLen(2))
+			Eventually(func() uint64 {
+				return fanOutEmitter.DeliveryFailures()["sink1"]
+			}).Should(BeNumerically("==", 2))
+			Expect(fanOutEmitter.DeliveryFailures()["sink2"]).To(BeNumerically("==", 0))
+		})
+
+		It("should log the failures of the sink at most once a minute", func() {
+			deliveryFailedLogs := func() []lager.LogFormat {
+				logs := []lager.LogFormat{}
+				for _, log := range testLogger.Logs() {
+					if log.Message == "fanout.delivery_failed" {
+						logs = append(logs, log)
+					}
+				}
+				return logs
+			}
+
+			fanOutEmitter.Emit(envelope)
+			Eventually(deliveryFailedLogs).Should(HaveLen(1))
+			fanOutEmitter.EmitBatch([]metrics.MetricEnvelope{envelope, envelope})
+			Eventually(func() uint64 {
+				return fanOutEmitter.DeliveryFailures()["sink1"]
+			}).Should(BeNumerically("==", 3))
+			Consistently(deliveryFailedLogs, 100*time.Millisecond).Should(HaveLen(1))
+
+			fakeClock.Increment(time.Minute)
+			fanOutEmitter.Emit(envelope)
+			Eventually(deliveryFailedLogs).Should(HaveLen(2))
+
+			logs := deliveryFailedLogs()
+			Expect(logs[0].Data).To(HaveKeyWithValue("sink", "sink1"))
+			Expect(logs[0].Data).To(HaveKeyWithValue("dropped", BeNumerically("==", 1)))
+			Expect(logs[0].Data).NotTo(HaveKey("envelopes"))
+			Expect(logs[1].Data).To(HaveKeyWithValue("dropped", BeNumerically("==", 3)))
+			Expect(logs[1].Data).To(HaveKeyWithValue("failures", BeNumerically("==", 4)))
+		})
+	})
+})
@@ -2,6 +2,9 @@ package emitter
 
 import (
 	"fmt"
+	"math"
+	"sort"
+	"strings"
 	"time"
 
 	"code.cloudfoundry.org/go-loggregator"
@@ -14,7 +17,7 @@ import (
 )
 
 // WithTimestamp overrides an envelope timestamp
-func WithTimestamp(timestamp int64) loggregator.EmitGaugeOption {
+func WithTimestamp(timestamp int64) func(proto.Message) {
 	return func(m proto.Message) {
 		switch e := m.(type) {
 		case *loggregator_v2.Envelope:
@@ -25,6 +28,15 @@ func WithTimestamp(timestamp int64) loggregator.EmitGaugeOption {
 	}
 }
 
+// fractionalCounterUnits are the units of the counters whose totals are not
+// whole numbers, such as the times in milliseconds. Counter envelopes only
+// hold whole totals, so these counters are sent as gauges to keep their
+// fractions.
+var fractionalCounterUnits = map[string]bool{
+	"ms": true,
+	"s":  true,
+}
+
 type LoggregatorEmitter struct {
 	loggregatorIngressClient *loggregator.IngressClient
 	logger                   lager.Logger
@@ -67,6 +79,20 @@ func NewLoggregatorEmitter(
 	}, nil
 }
 
+// CheckLoggregatorCerts returns an error if the certificates and key of the
+// config cannot be read and parsed, without connecting to loggregator
+func CheckLoggregatorCerts(emitterConfig config.LoggregatorEmitterConfig) error {
+	_, err := loggregator.NewIngressTLSConfig(
+		emitterConfig.CACertPath,
+		emitterConfig.CertPath,
+		emitterConfig.KeyPath,
+	)
+	if err != nil {
+		return fmt.Errorf("loggregator_emitter certificates: %s", err)
+	}
+	return nil
+}
+
 func (e *LoggregatorEmitter) Emit(me metrics.MetricEnvelope) {
 	e.logger.Debug("emit", lager.Data{
 		"envelope": me,
@@ -77,6 +103,16 @@ func (e *LoggregatorEmitter) Emit(me metrics.MetricEnvelope) {
 	} else {
 		timestamp = time.Now().UnixNano()
 	}
+	if sentAsCounter(me.Metric) {
+		e.loggregatorIngressClient.EmitCounter(
+			me.Metric.Key,
+			loggregator.WithTotal(uint64(math.Round(me.Metric.Value))),
+			loggregator.WithCounterSourceInfo(me.InstanceGUID, "0"),
+			WithTimestamp(timestamp),
+			loggregator.WithEnvelopeTags(me.Metric.Tags),
+		)
+		return
+	}
 	e.loggregatorIngressClient.EmitGauge(
 		loggregator.WithGaugeValue(me.Metric.Key, me.Metric.Value, me.Metric.Unit),
 		loggregator.WithGaugeSourceInfo(me.InstanceGUID, "0"),
@@ -84,3 +120,80 @@ func (e *LoggregatorEmitter) Emit(me metrics.MetricEnvelope) {
 		loggregator.WithEnvelopeTags(me.Metric.Tags),
 	)
 }
+
+// EmitBatch emits the envelopes of one collection. Gauges that share the
+// same source, timestamp and tags are packed in a single gauge envelope.
+// Counters are emitted on their own, as counter envelopes hold one value,
+// apart from the ones sent as gauges.
+func (e *LoggregatorEmitter) EmitBatch(envelopes []metrics.MetricEnvelope) {
+	e.logger.Debug("emit_batch", lager.Data{
+		"envelopes": envelopes,
+	})
+	now := time.Now().UnixNano()
+
+	type gaugeGroup struct {
+		sourceID  string
+		timestamp int64
+		tags      map[string]string
+		values    []loggregator.EmitGaugeOption
+	}
+	groups := map[string]*gaugeGroup{}
+	groupOrder := []string{}
+
+	for _, me := range envelopes {
+		if sentAsCounter(me.Metric) {
+			e.Emit(me)
+			continue
+		}
+
+		timestamp := me.Metric.Timestamp
+		if timestamp == 0 {
+			timestamp = now
+		}
+
+		id := gaugeGroupID(me.InstanceGUID, timestamp, me.Metric.Tags)
+		group, ok := groups[id]
+		if !ok {
+			group = &gaugeGroup{
+				sourceID:  me.InstanceGUID,
+				timestamp: timestamp,
+				tags:      me.Metric.Tags,
+			}
+			groups[id] = group
+			groupOrder = append(groupOrder, id)
+		}
+		group.values = append(group.values,
+			loggregator.WithGaugeValue(me.Metric.Key, me.Metric.Value, me.Metric.Unit),
+		)
+	}
+
+	for _, id := range groupOrder {
+		group := groups[id]
+		opts := append(group.values,
+			loggregator.WithGaugeSourceInfo(group.sourceID, "0"),
+			WithTimestamp(group.timestamp),
+			loggregator.WithEnvelopeTags(group.tags),
+		)
+		e.loggregatorIngressClient.EmitGauge(opts...)
+	}
+}
+
+// sentAsCounter tells if the metric is sent as a counter envelope, which
+// only holds a whole total
+func sentAsCounter(m metrics.Metric) bool {
+	return m.Kind == metrics.Counter && !fractionalCounterUnits[m.Unit]
+}
+
+func gaugeGroupID(sourceID string, timestamp int64, tags map[string]string) string {
+	tagNames := make([]string, 0, len(tags))
+	for k := range tags {
+		tagNames = append(tagNames, k)
+	}
+	sort.Strings(tagNames)
+
+	parts := []string{sourceID, fmt.Sprint(timestamp)}
+	for _, k := range tagNames {
+		parts = append(parts, k+"="+tags[k])
+	}
+	return strings.Join(parts, "\x00")
+}
@@ -94,6 +94,20 @@ var _ = Describe("IngressClient", func() {
 		Expect(err).To(HaveOccurred())
 	})
 
+	It("should check that the cert files can be parsed", func() {
+		Expect(emitter.CheckLoggregatorCerts(emitterConfig)).To(Succeed())
+
+		newEmitterConfig := emitterConfig
+		newEmitterConfig.CertPath = "./fixtures/invalid-cert.data"
+		Expect(emitter.CheckLoggregatorCerts(newEmitterConfig)).To(
+			MatchError(ContainSubstring("loggregator_emitter certificates")),
+		)
+
+		newEmitterConfig = emitterConfig
+		newEmitterConfig.CACertPath = "missing"
+		Expect(emitter.CheckLoggregatorCerts(newEmitterConfig)).ToNot(Succeed())
+	})
+
 	It("should emit one metric as gauge", func() {
 		loggregatorEmitter.Emit(
 			metrics.MetricEnvelope{
@@ -115,6 +129,68 @@ var _ = Describe("IngressClient", func() {
 		Expect(envelope.GetGauge().GetMetrics()["a_key"].Unit).To(Equal("bytes"))
 	})
 
+	It("should emit a counter metric as a counter with its total", func() {
+		loggregatorEmitter.Emit(
+			metrics.MetricEnvelope{
+				InstanceGUID: "instance-guid",
+				Metric: metrics.Metric{
+					Key:   "commits",
+					Value: 42,
+					Unit:  "tx",
+					Kind:  metrics.Counter,
+					Tags:  map[string]string{"source": "sql"},
+				},
+			},
+		)
+
+		var envelope *loggregator_v2.Envelope
+		Eventually(server.ReceivedEnvelopes, 1*time.Second).Should(Receive(&envelope))
+		Expect(envelope.GetSourceId()).To(Equal("instance-guid"))
+		Expect(envelope.GetGauge()).To(BeNil())
+		Expect(envelope.GetCounter()).NotTo(BeNil())
+		Expect(envelope.GetCounter().GetName()).To(Equal("commits"))
+		Expect(envelope.GetCounter().GetTotal()).To(BeNumerically("==", 42))
+		Expect(envelope.GetCounter().GetDelta()).To(BeNumerically("==", 0))
+		Expect(envelope.GetTags()).To(HaveKeyWithValue("source", "sql"))
+	})
+
+	It("should emit a counter of fractional milliseconds as a gauge to keep its fraction", func() {
+		loggregatorEmitter.Emit(
+			metrics.MetricEnvelope{
+				InstanceGUID: "instance-guid",
+				Metric: metrics.Metric{
+					Key:   "read_time",
+					Value: 1234.567,
+					Unit:  "ms",
+					Kind:  metrics.Counter,
+					Tags:  map[string]string{"source": "sql"},
+				},
+			},
+		)
+
+		var envelope *loggregator_v2.Envelope
+		Eventually(server.ReceivedEnvelopes, 1*time.Second).Should(Receive(&envelope))
+		Expect(envelope.GetCounter()).To(BeNil())
+		Expect(envelope.GetGauge()).NotTo(BeNil())
+		Expect(envelope.GetGauge().GetMetrics()).To(HaveKey("read_time"))
+		Expect(envelope.GetGauge().GetMetrics()["read_time"].Value).To(Equal(1234.567))
+		Expect(envelope.GetGauge().GetMetrics()["read_time"].Unit).To(Equal("ms"))
+	})
+
+	It("should round the total of a fractional counter in another unit", func() {
+		loggregatorEmitter.Emit(
+			metrics.MetricEnvelope{
+				InstanceGUID: "instance-guid",
+				Metric:       metrics.Metric{Key: "things", Value: 41.6, Unit: "thing", Kind: metrics.Counter},
+			},
+		)
+
+		var envelope *loggregator_v2.Envelope
+		Eventually(server.ReceivedEnvelopes, 1*time.Second).Should(Receive(&envelope))
+		Expect(envelope.GetCounter()).NotTo(BeNil())
+		Expect(envelope.GetCounter().GetTotal()).To(BeNumerically("==", 42))
+	})
+
 	It("should emit multiple metrics from different souces as gauges", func() {
 		loggregatorEmitter.Emit(
 			metrics.MetricEnvelope{
@@ -170,6 +246,59 @@ var _ = Describe("IngressClient", func() {
 		Expect(envelope.GetGauge().GetMetrics()["connections"].Unit).To(Equal("conn"))
 	})
 
+	It("should pack a batch of gauges with the same source, timestamp and tags in one envelope", func() {
+		sqlTags := map[string]string{"source": "sql"}
+		loggregatorEmitter.EmitBatch([]metrics.MetricEnvelope{
+			{
+				InstanceGUID: "instance-guid",
+				Metric:       metrics.Metric{Key: "connections", Value: 1, Unit: "conn", Tags: sqlTags},
+			},
+			{
+				InstanceGUID: "instance-guid",
+				Metric:       metrics.Metric{Key: "max_connections", Value: 2, Unit: "conn", Tags: sqlTags},
+			},
+			{
+				InstanceGUID: "instance-guid",
+				Metric:       metrics.Metric{Key: "commits", Value: 3, Unit: "tx", Kind: metrics.Counter, Tags: sqlTags},
+			},
+			{
+				InstanceGUID: "instance-guid",
+				Metric: metrics.Metric{
+					Key:   "dbsize",
+					Value: 4,
+					Unit:  "byte",
+					Tags:  map[string]string{"source": "sql", "dbname": "foo"},
+				},
+			},
+		})
+
+		envelopes := []*loggregator_v2.Envelope{}
+		for i := 0; i < 3; i++ {
+			var envelope *loggregator_v2.Envelope
+			Eventually(server.ReceivedEnvelopes, 1*time.Second).Should(Receive(&envelope))
+			envelopes = append(envelopes, envelope)
+		}
+		Consistently(server.ReceivedEnvelopes, 200*time.Millisecond).ShouldNot(Receive())
+
+		gauges := map[string]*loggregator_v2.Envelope{}
+		for _, envelope := range envelopes {
+			Expect(envelope.GetSourceId()).To(Equal("instance-guid"))
+			if envelope.GetCounter() != nil {
+				Expect(envelope.GetCounter().GetName()).To(Equal("commits"))
+				Expect(envelope.GetCounter().GetTotal()).To(BeNumerically("==", 3))
+				continue
+			}
+			gauges[envelope.GetTags()["dbname"]] = envelope
+		}
+
+		Expect(gauges).To(HaveLen(2))
+		Expect(gauges[""].GetGauge().GetMetrics()).To(HaveLen(2))
+		Expect(gauges[""].GetGauge().GetMetrics()["connections"].Value).To(Equal(1.0))
+		Expect(gauges[""].GetGauge().GetMetrics()["max_connections"].Value).To(Equal(2.0))
+		Expect(gauges["foo"].GetGauge().GetMetrics()).To(HaveLen(1))
+		Expect(gauges["foo"].GetGauge().GetMetrics()["dbsize"].Unit).To(Equal("byte"))
+	})
+
 	It("should preserve the metric timestamp if it is not 0", func() {
 		metricTime := time.Now().Add(-1 * time.Hour)
 
@@ -0,0 +1,259 @@
+package emitter
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/tedsuo/ifrit"
+	"github.com/tedsuo/ifrit/http_server"
+
+	"github.com/alphagov/paas-rds-metric-collector/pkg/config"
+	"github.com/alphagov/paas-rds-metric-collector/pkg/metrics"
+)
+
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var invalidPrometheusNameChars = regexp.MustCompile("[^a-zA-Z0-9_]")
+
+var prometheusLabelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// PrometheusEmitter keeps the latest value of every series it has been
+// given and serves them in the Prometheus text exposition format. Series not
+// updated for seriesTTL, such as the ones of deleted instances or dropped
+// tables, are forgotten. They are dropped when scraped, and at most every
+// seriesTTL when written, so that they do not pile up if nothing scrapes.
+//
+// The metrics whose kind differs from the kind first seen for their name, and
+// the tags whose names are sanitised into the same label, are logged once, as
+// they cannot be exposed as they are.
+type PrometheusEmitter struct {
+	listenAddress string
+	seriesTTL     time.Duration
+	clock         clock.Clock
+	logger        lager.Logger
+
+	mutex           sync.Mutex
+	series          map[string]prometheusSeries
+	lastPruned      time.Time
+	kinds           map[string]metrics.MetricKind
+	loggedConflicts map[string]bool
+}
+
+type prometheusSeries struct {
+	envelope  metrics.MetricEnvelope
+	updatedAt time.Time
+}
+
+// NewPrometheusEmitter ...
+func NewPrometheusEmitter(
+	emitterConfig config.PrometheusEmitterConfig,
+	clock clock.Clock,
+	logger lager.Logger,
+) *PrometheusEmitter {
+	return &PrometheusEmitter{
+		listenAddress: emitterConfig.ListenAddress,
+		seriesTTL:     time.Duration(emitterConfig.SeriesTTLSeconds) * time.Second,
+		clock:         clock,
+		logger:        logger,
+		series:        map[string]prometheusSeries{},
+		kinds:         map[string]metrics.MetricKind{},
+
+		loggedConflicts: map[string]bool{},
+	}
+}
+
+// Emit stores the envelope, replacing any previous value of the same series
+func (e *PrometheusEmitter) Emit(me metrics.MetricEnvelope) {
+	e.logger.Debug("emit", lager.Data{
+		"envelope": me,
+	})
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	now := e.clock.Now()
+	e.checkConflicts(me)
+	e.series[prometheusSeriesID(me)] = prometheusSeries{
+		envelope:  me,
+		updatedAt: now,
+	}
+	if e.seriesTTL > 0 && now.Sub(e.lastPruned) >= e.seriesTTL {
+		e.pruneStaleSeries(now)
+	}
+}
+
+// checkConflicts logs, once for each of them, the conflicts of the envelope
+// with the series of the same name
+func (e *PrometheusEmitter) checkConflicts(me metrics.MetricEnvelope) {
+	name := prometheusName(me.Metric.Key)
+	kind, ok := e.kinds[name]
+	if !ok {
+		e.kinds[name] = me.Metric.Kind
+	} else if kind != me.Metric.Kind && !e.loggedConflicts["kind\x00"+name] {
+		e.loggedConflicts["kind\x00"+name] = true
+		e.logger.Error("conflicting_metric_kinds", fmt.Errorf("%s is exposed as a %s", name, kind), lager.Data{
+			"metric": me.Metric.Key,
+			"kind":   me.Metric.Kind.String(),
+		})
+	}
+
+	tagNames := map[string]string{}
+	for k := range me.Metric.Tags {
+		label := prometheusName(k)
+		other, ok := tagNames[label]
+		tagNames[label] = k
+		if !ok || e.loggedConflicts["label\x00"+name+"\x00"+label] {
+			continue
+		}
+		e.loggedConflicts["label\x00"+name+"\x00"+label] = true
+		e.logger.Error("conflicting_label_names", fmt.Errorf("tags %s and %s are both exposed as %s", other, k, label), lager.Data{
+			"metric": me.Metric.Key,
+			"label":  label,
+		})
+	}
+}
+
+// EmitBatch stores all the envelopes
+func (e *PrometheusEmitter) EmitBatch(envelopes []metrics.MetricEnvelope) {
+	for _, me := range envelopes {
+		e.Emit(me)
+	}
+}
+
+// ServeHTTP writes all the stored series in the Prometheus text format,
+// after dropping the stale ones
+func (e *PrometheusEmitter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	envelopes, kinds := e.currentEnvelopes()
+
+	byName := map[string][]metrics.MetricEnvelope{}
+	for _, me := range envelopes {
+		name := prometheusName(me.Metric.Key)
+		byName[name] = append(byName[name], me)
+	}
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		lines := []string{}
+		for _, me := range byName[name] {
+			lines = append(lines, fmt.Sprintf(
+				"%s%s %s\n",
+				name,
+				prometheusLabels(me),
+				strconv.FormatFloat(me.Metric.Value, 'g', -1, 64),
+			))
+		}
+		sort.Strings(lines)
+
+		fmt.Fprintf(&b, "# TYPE %s %s\n", name, kinds[name])
+		for _, line := range lines {
+			b.WriteString(line)
+		}
+	}
+
+	w.Header().Set("Content-Type", prometheusContentType)
+	_, err := w.Write([]byte(b.String()))
+	if err != nil {
+		e.logger.Error("writing prometheus response", err)
+	}
+}
+
+// currentEnvelopes drops the series not updated for seriesTTL and returns the
+// envelopes of the others, and the kind each name is exposed as
+func (e *PrometheusEmitter) currentEnvelopes() ([]metrics.MetricEnvelope, map[string]metrics.MetricKind) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.seriesTTL > 0 {
+		e.pruneStaleSeries(e.clock.Now())
+	}
+	envelopes := make([]metrics.MetricEnvelope, 0, len(e.series))
+	for _, s := range e.series {
+		envelopes = append(envelopes, s.envelope)
+	}
+	kinds := make(map[string]metrics.MetricKind, len(e.kinds))
+	for name, kind := range e.kinds {
+		kinds[name] = kind
+	}
+	return envelopes, kinds
+}
+
+// pruneStaleSeries drops the series not updated for seriesTTL. It must be
+// called with the mutex held.
+func (e *PrometheusEmitter) pruneStaleSeries(now time.Time) {
+	dropped := 0
+	for id, s := range e.series {
+		if now.Sub(s.updatedAt) > e.seriesTTL {
+			delete(e.series, id)
+			dropped++
+		}
+	}
+	e.lastPruned = now
+	if dropped > 0 {
+		e.logger.Debug("dropped_stale_series", lager.Data{"count": dropped})
+	}
+}
+
+// Runner returns an ifrit.Runner serving the `/metrics` endpoint
+func (e *PrometheusEmitter) Runner() ifrit.Runner {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+	return http_server.New(e.listenAddress, mux)
+}
+
+func prometheusSeriesID(me metrics.MetricEnvelope) string {
+	tagNames := make([]string, 0, len(me.Metric.Tags))
+	for k := range me.Metric.Tags {
+		tagNames = append(tagNames, k)
+	}
+	sort.Strings(tagNames)
+
+	id := me.InstanceGUID + "\x00" + me.Metric.Key
+	for _, k := range tagNames {
+		id += "\x00" + k + "=" + me.Metric.Tags[k]
+	}
+	return id
+}
+
+func prometheusName(name string) string {
+	name = invalidPrometheusNameChars.ReplaceAllString(name, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+func prometheusLabels(me metrics.MetricEnvelope) string {
+	labels := map[string]string{}
+	for k, v := range me.Metric.Tags {
+		labels[prometheusName(k)] = v
+	}
+	// The collector telemetry is sent under the collector's source ID, and
+	// tagged with the instance it is about
+	if _, ok := labels["instance_guid"]; !ok {
+		labels["instance_guid"] = me.InstanceGUID
+	}
+
+	labelNames := make([]string, 0, len(labels))
+	for k := range labels {
+		labelNames = append(labelNames, k)
+	}
+	sort.Strings(labelNames)
+
+	pairs := make([]string, 0, len(labelNames))
+	for _, k := range labelNames {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, k, prometheusLabelValueEscaper.Replace(labels[k])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
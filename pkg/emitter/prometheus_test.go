@@ -0,0 +1,223 @@
+package emitter_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+
+	"github.com/alphagov/paas-rds-metric-collector/pkg/config"
+	"github.com/alphagov/paas-rds-metric-collector/pkg/emitter"
+	"github.com/alphagov/paas-rds-metric-collector/pkg/metrics"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PrometheusEmitter", func() {
+	var (
+		fakeClock         *fakeclock.FakeClock
+		testLogger        *lagertest.TestLogger
+		prometheusEmitter *emitter.PrometheusEmitter
+	)
+
+	logsWithMessage := func(message string) []lager.LogFormat {
+		logs := []lager.LogFormat{}
+		for _, log := range testLogger.Logs() {
+			if log.Message == message {
+				logs = append(logs, log)
+			}
+		}
+		return logs
+	}
+
+	scrape := func() (*http.Response, string) {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("GET", "/metrics", nil)
+		prometheusEmitter.ServeHTTP(recorder, request)
+		return recorder.Result(), recorder.Body.String()
+	}
+
+	BeforeEach(func() {
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		testLogger = lagertest.NewTestLogger("prometheus")
+		prometheusEmitter = emitter.NewPrometheusEmitter(
+			config.PrometheusEmitterConfig{ListenAddress: "127.0.0.1:0", SeriesTTLSeconds: 60},
+			fakeClock,
+			testLogger,
+		)
+	})
+
+	It("should serve an empty page if no metrics were emitted", func() {
+		response, body := scrape()
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+		Expect(response.Header.Get("Content-Type")).To(HavePrefix("text/plain; version=0.0.4"))
+		Expect(body).To(BeEmpty())
+	})
+
+	It("should expose the metric as a gauge with the instance guid and tags as labels", func() {
+		prometheusEmitter.Emit(metrics.MetricEnvelope{
+			InstanceGUID: "instance-guid",
+			Metric: metrics.Metric{
+				Key:   "connections",
+				Value: 3,
+				Unit:  "conn",
+				Tags:  map[string]string{"source": "sql", "dbname": "foo"},
+			},
+		})
+
+		_, body := scrape()
+		Expect(body).To(Equal(
+			"# TYPE connections gauge\n" +
+				`connections{dbname="foo",instance_guid="instance-guid",source="sql"} 3` + "\n",
+		))
+	})
+
+	It("should expose counter metrics as counters", func() {
+		prometheusEmitter.Emit(metrics.MetricEnvelope{
+			InstanceGUID: "instance-guid",
+			Metric:       metrics.Metric{Key: "commits", Value: 42, Kind: metrics.Counter},
+		})
+
+		_, body := scrape()
+		Expect(body).To(Equal(
+			"# TYPE commits counter\n" +
+				`commits{instance_guid="instance-guid"} 42` + "\n",
+		))
+	})
+
+	It("should keep only the latest value of each series", func() {
+		for _, v := range []float64{1, 2, 3.5} {
+			prometheusEmitter.Emit(metrics.MetricEnvelope{
+				InstanceGUID: "instance-guid",
+				Metric:       metrics.Metric{Key: "dbsize", Value: v, Tags: map[string]string{"source": "sql"}},
+			})
+		}
+		prometheusEmitter.Emit(metrics.MetricEnvelope{
+			InstanceGUID: "instance-guid-2",
+			Metric:       metrics.Metric{Key: "dbsize", Value: 10, Tags: map[string]string{"source": "sql"}},
+		})
+
+		_, body := scrape()
+		Expect(body).To(Equal(
+			"# TYPE dbsize gauge\n" +
+				`dbsize{instance_guid="instance-guid",source="sql"} 3.5` + "\n" +
+				`dbsize{instance_guid="instance-guid-2",source="sql"} 10` + "\n",
+		))
+	})
+
+	It("should drop the series not updated for the series TTL", func() {
+		prometheusEmitter.Emit(metrics.MetricEnvelope{
+			InstanceGUID: "deleted-instance-guid",
+			Metric:       metrics.Metric{Key: "dbsize", Value: 1},
+		})
+		fakeClock.Increment(30 * time.Second)
+		prometheusEmitter.Emit(metrics.MetricEnvelope{
+			InstanceGUID: "instance-guid",
+			Metric:       metrics.Metric{Key: "dbsize", Value: 2},
+		})
+
+		fakeClock.Increment(30 * time.Second)
+		_, body := scrape()
+		Expect(body).To(ContainSubstring(`dbsize{instance_guid="deleted-instance-guid"} 1`))
+
+		fakeClock.Increment(time.Second)
+		_, body = scrape()
+		Expect(body).To(Equal(
+			"# TYPE dbsize gauge\n" +
+				`dbsize{instance_guid="instance-guid"} 2` + "\n",
+		))
+	})
+
+	It("should drop the stale series when written to, even if never scraped", func() {
+		prometheusEmitter.Emit(metrics.MetricEnvelope{
+			InstanceGUID: "deleted-instance-guid",
+			Metric:       metrics.Metric{Key: "dbsize", Value: 1},
+		})
+		fakeClock.Increment(61 * time.Second)
+		prometheusEmitter.Emit(metrics.MetricEnvelope{
+			InstanceGUID: "instance-guid",
+			Metric:       metrics.Metric{Key: "dbsize", Value: 2},
+		})
+
+		logs := logsWithMessage("prometheus.dropped_stale_series")
+		Expect(logs).To(HaveLen(1))
+		Expect(logs[0].Data).To(HaveKeyWithValue("count", BeNumerically("==", 1)))
+	})
+
+	It("should log once the metrics emitted with another kind than the first one", func() {
+		for _, kind := range []metrics.MetricKind{metrics.Counter, metrics.Gauge, metrics.Gauge} {
+			prometheusEmitter.Emit(metrics.MetricEnvelope{
+				InstanceGUID: "instance-guid",
+				Metric: metrics.Metric{
+					Key:   "commits",
+					Value: 1,
+					Kind:  kind,
+					Tags:  map[string]string{"kind": kind.String()},
+				},
+			})
+		}
+
+		logs := logsWithMessage("prometheus.conflicting_metric_kinds")
+		Expect(logs).To(HaveLen(1))
+		Expect(logs[0].Data).To(HaveKeyWithValue("metric", "commits"))
+		Expect(logs[0].Data).To(HaveKeyWithValue("kind", "gauge"))
+
+		_, body := scrape()
+		Expect(body).To(HavePrefix("# TYPE commits counter\n"))
+	})
+
+	It("should log once the tags exposed as the same label", func() {
+		for i := 0; i < 2; i++ {
+			prometheusEmitter.Emit(metrics.MetricEnvelope{
+				InstanceGUID: "instance-guid",
+				Metric: metrics.Metric{
+					Key:   "connections",
+					Value: 1,
+					Tags:  map[string]string{"db-name": "a", "db_name": "b"},
+				},
+			})
+		}
+
+		logs := logsWithMessage("prometheus.conflicting_label_names")
+		Expect(logs).To(HaveLen(1))
+		Expect(logs[0].Data).To(HaveKeyWithValue("metric", "connections"))
+		Expect(logs[0].Data).To(HaveKeyWithValue("label", "db_name"))
+	})
+
+	It("should keep the instance guid tag of the collector telemetry", func() {
+		prometheusEmitter.Emit(metrics.MetricEnvelope{
+			InstanceGUID: "rds-metric-collector",
+			Metric: metrics.Metric{
+				Key:   "consecutive_errors",
+				Value: 2,
+				Tags:  map[string]string{"source": "collector", "instance_guid": "instance-guid"},
+			},
+		})
+
+		_, body := scrape()
+		Expect(body).To(ContainSubstring(
+			`consecutive_errors{instance_guid="instance-guid",source="collector"} 2`,
+		))
+	})
+
+	It("should sanitise metric and label names and escape label values", func() {
+		prometheusEmitter.Emit(metrics.MetricEnvelope{
+			InstanceGUID: "instance-guid",
+			Metric: metrics.Metric{
+				Key:   "read.iops",
+				Value: 1,
+				Tags:  map[string]string{"db-name": "a \"quoted\"\nvalue\\"},
+			},
+		})
+
+		_, body := scrape()
+		Expect(body).To(ContainSubstring("# TYPE read_iops gauge\n"))
+		Expect(body).To(ContainSubstring(
+			`read_iops{db_name="a \"quoted\"\nvalue\\",instance_guid="instance-guid"} 1`,
+		))
+	})
+})
@@ -13,3 +13,10 @@ type StdOutEmitter struct{}
 func (s *StdOutEmitter) Emit(m metrics.MetricEnvelope) {
 	fmt.Println("============>", m)
 }
+
+// EmitBatch ...
+func (s *StdOutEmitter) EmitBatch(envelopes []metrics.MetricEnvelope) {
+	for _, m := range envelopes {
+		s.Emit(m)
+	}
+}
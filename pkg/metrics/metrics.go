@@ -1,11 +1,31 @@
 package metrics
 
+// MetricKind tells how the value of a metric should be interpreted
+type MetricKind int
+
+const (
+	// Gauge is a value that can go up and down
+	Gauge MetricKind = iota
+	// Counter is a monotonically increasing total, reset only on restart
+	Counter
+)
+
+func (k MetricKind) String() string {
+	switch k {
+	case Counter:
+		return "counter"
+	default:
+		return "gauge"
+	}
+}
+
 // Metric ...
 type Metric struct {
 	Key       string
 	Timestamp int64
 	Value     float64
 	Unit      string
+	Kind      MetricKind
 	Tags      map[string]string
 }
 
@@ -14,3 +34,20 @@ type MetricEnvelope struct {
 	InstanceGUID string
 	Metric       Metric
 }
+
+// WithTags returns a copy of the envelope with the tags added to the tags of
+// its metric. The tags of the metric win over the added ones.
+func (e MetricEnvelope) WithTags(tags map[string]string) MetricEnvelope {
+	if len(tags) == 0 {
+		return e
+	}
+	mergedTags := make(map[string]string, len(tags)+len(e.Metric.Tags))
+	for k, v := range tags {
+		mergedTags[k] = v
+	}
+	for k, v := range e.Metric.Tags {
+		mergedTags[k] = v
+	}
+	e.Metric.Tags = mergedTags
+	return e
+}
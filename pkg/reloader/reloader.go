@@ -0,0 +1,63 @@
+package reloader
+
+import (
+	"os"
+	"syscall"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/tedsuo/ifrit"
+)
+
+// ReloadSignal is the signal that makes the Reloader reload the config
+var ReloadSignal os.Signal = syscall.SIGHUP
+
+// Reloader runs a runner and calls a reload function every time it receives
+// ReloadSignal. The other signals are passed on to the runner, which keeps
+// running whether the reload succeeds or not.
+//
+// It must be wrapped by sigmon with ReloadSignal, so that the signal is
+// handled rather than killing the process:
+//
+//	sigmon.New(reloader.New(group, reload, logger), reloader.ReloadSignal)
+type Reloader struct {
+	runner ifrit.Runner
+	reload func() error
+	logger lager.Logger
+}
+
+// New ...
+func New(runner ifrit.Runner, reload func() error, logger lager.Logger) *Reloader {
+	return &Reloader{
+		runner: runner,
+		reload: reload,
+		logger: logger,
+	}
+}
+
+// Run runs the wrapped runner
+func (r *Reloader) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	process := ifrit.Background(r.runner)
+	processReady := process.Ready()
+	processWait := process.Wait()
+
+	for {
+		select {
+		case sig := <-signals:
+			if sig != ReloadSignal {
+				process.Signal(sig)
+				continue
+			}
+			r.logger.Info("reloading")
+			if err := r.reload(); err != nil {
+				r.logger.Error("reload-failed", err)
+				continue
+			}
+			r.logger.Info("reloaded")
+		case <-processReady:
+			close(ready)
+			processReady = nil
+		case err := <-processWait:
+			return err
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package reloader_test
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+
+	"github.com/tedsuo/ifrit"
+
+	"github.com/alphagov/paas-rds-metric-collector/pkg/reloader"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reloader", func() {
+	var (
+		reloads     atomic.Int32
+		reloadError error
+		signalled   chan os.Signal
+		process     ifrit.Process
+	)
+
+	BeforeEach(func() {
+		reloads.Store(0)
+		reloadError = nil
+		signalled = make(chan os.Signal, 1)
+
+		runner := ifrit.RunFunc(func(signals <-chan os.Signal, ready chan<- struct{}) error {
+			close(ready)
+			sig := <-signals
+			signalled <- sig
+			return nil
+		})
+		process = ifrit.Background(reloader.New(runner, func() error {
+			reloads.Add(1)
+			return reloadError
+		}, logger))
+		Eventually(process.Ready()).Should(BeClosed())
+	})
+
+	It("reloads on the reload signal without signalling the runner", func() {
+		process.Signal(reloader.ReloadSignal)
+		Eventually(reloads.Load).Should(BeEquivalentTo(1))
+		Consistently(signalled).ShouldNot(Receive())
+		Expect(process.Wait()).ToNot(Receive())
+	})
+
+	It("keeps running if the reload fails", func() {
+		reloadError = errors.New("invalid config")
+
+		process.Signal(reloader.ReloadSignal)
+		Eventually(reloads.Load).Should(BeEquivalentTo(1))
+		process.Signal(reloader.ReloadSignal)
+		Eventually(reloads.Load).Should(BeEquivalentTo(2))
+		Consistently(process.Wait()).ShouldNot(Receive())
+	})
+
+	It("passes the other signals on to the runner", func() {
+		process.Signal(os.Interrupt)
+		Eventually(signalled).Should(Receive(Equal(os.Interrupt)))
+		Eventually(process.Wait()).Should(Receive(BeNil()))
+		Expect(reloads.Load()).To(BeEquivalentTo(0))
+	})
+})
@@ -0,0 +1,40 @@
+package scheduler
+
+import (
+	"sync"
+
+	"github.com/alphagov/paas-rds-metric-collector/pkg/brokerinfo"
+)
+
+// knownInstances holds the details of the instances from the last refresh.
+// They are updated on every refresh, so the running workers see the changes
+// of their instance, such as new tags, without being restarted.
+type knownInstances struct {
+	mutex     sync.RWMutex
+	instances map[string]brokerinfo.InstanceInfo
+}
+
+func newKnownInstances() *knownInstances {
+	return &knownInstances{instances: map[string]brokerinfo.InstanceInfo{}}
+}
+
+func (k *knownInstances) set(instanceInfos []brokerinfo.InstanceInfo) {
+	instances := make(map[string]brokerinfo.InstanceInfo, len(instanceInfos))
+	for _, instanceInfo := range instanceInfos {
+		instances[instanceInfo.GUID] = instanceInfo
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	k.instances = instances
+}
+
+// get returns the details of the instance from the last refresh
+func (k *knownInstances) get(instanceGUID string) (brokerinfo.InstanceInfo, bool) {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	instanceInfo, ok := k.instances[instanceGUID]
+	return instanceInfo, ok
+}
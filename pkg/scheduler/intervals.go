@@ -0,0 +1,82 @@
+package scheduler
+
+import (
+	"sync"
+
+	"github.com/alphagov/paas-rds-metric-collector/pkg/brokerinfo"
+	"github.com/alphagov/paas-rds-metric-collector/pkg/config"
+)
+
+// intervalOverrides holds the collection intervals configured for some
+// instances. The workers read them every time they schedule a collection, so
+// the overrides can be replaced while the workers run.
+type intervalOverrides struct {
+	mutex     sync.RWMutex
+	overrides []config.IntervalOverrideConfig
+}
+
+func newIntervalOverrides(overrides []config.IntervalOverrideConfig) *intervalOverrides {
+	return &intervalOverrides{overrides: overrides}
+}
+
+func (o *intervalOverrides) set(overrides []config.IntervalOverrideConfig) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.overrides = overrides
+}
+
+// interval returns the collection interval of the worker in seconds. An
+// override by instance GUID wins over one by tag, which wins over one by
+// engine. For the same match, an override of the worker's driver wins over
+// one of every driver. If no override matches, defaultInterval is returned.
+func (o *intervalOverrides) interval(id workerID, instanceInfo brokerinfo.InstanceInfo, defaultInterval int) int {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	interval := defaultInterval
+	best := -1
+	for _, override := range o.overrides {
+		score := overrideScore(override, id, instanceInfo)
+		if score > best {
+			best = score
+			interval = override.Interval
+		}
+	}
+	return interval
+}
+
+// overrideScore returns how specific the override is for the worker, or -1 if
+// it does not apply to it
+func overrideScore(override config.IntervalOverrideConfig, id workerID, instanceInfo brokerinfo.InstanceInfo) int {
+	if override.Driver != "" && override.Driver != id.Driver {
+		return -1
+	}
+
+	var score int
+	switch {
+	case override.InstanceGUID != "":
+		if override.InstanceGUID != id.InstanceGUID {
+			return -1
+		}
+		score = 4
+	case override.TagKey != "":
+		value, ok := instanceInfo.Tags[override.TagKey]
+		if !ok || value != override.TagValue {
+			return -1
+		}
+		score = 2
+	case override.Engine != "":
+		if override.Engine != instanceInfo.Type {
+			return -1
+		}
+		score = 0
+	default:
+		return -1
+	}
+
+	if override.Driver != "" {
+		score++
+	}
+	return score
+}
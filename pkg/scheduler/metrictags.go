@@ -0,0 +1,57 @@
+package scheduler
+
+import (
+	"sync"
+
+	"github.com/alphagov/paas-rds-metric-collector/pkg/config"
+	"github.com/alphagov/paas-rds-metric-collector/pkg/metrics"
+)
+
+// metricTags adds the details of the instances listed in the config as tags
+// to the metrics of the instances. The details come from the last refresh,
+// so the workers do not need to be restarted when an instance is upgraded or
+// its plan changes.
+type metricTags struct {
+	mutex     sync.RWMutex
+	config    config.MetricTagsConfig
+	instances *knownInstances
+}
+
+func newMetricTags(instances *knownInstances) *metricTags {
+	return &metricTags{instances: instances}
+}
+
+func (t *metricTags) setConfig(metricTagsConfig config.MetricTagsConfig) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.config = metricTagsConfig
+}
+
+// add adds the tags of the instance to the metric of the envelope. Envelopes
+// of unknown instances, such as the telemetry, are left as they are.
+func (t *metricTags) add(envelope metrics.MetricEnvelope) metrics.MetricEnvelope {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	instanceInfo, ok := t.instances.get(envelope.InstanceGUID)
+	if !ok {
+		return envelope
+	}
+	return envelope.WithTags(instanceInfo.MetricTags(t.config))
+}
+
+// addTo adds the tags of the instances to the metrics of the envelopes
+func (t *metricTags) addTo(envelopes []metrics.MetricEnvelope) []metrics.MetricEnvelope {
+	tagged := make([]metrics.MetricEnvelope, 0, len(envelopes))
+	for _, envelope := range envelopes {
+		tagged = append(tagged, t.add(envelope))
+	}
+	return tagged
+}
+
+// SetMetricTags replaces the list of instance details added as tags to the
+// metrics. Running workers use it from their next collection on.
+func (s *Scheduler) SetMetricTags(metricTagsConfig config.MetricTagsConfig) {
+	s.metricTags.setConfig(metricTagsConfig)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"math"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"code.cloudfoundry.org/lager/v3"
@@ -27,10 +28,7 @@ type Scheduler struct {
 	brokerinfo     brokerinfo.BrokerInfo
 	metricsEmitter emitter.MetricsEmitter
 
-	instanceRefreshInterval int
-	collectorRetryInterval  int
-	collectorMaxRetries     int
-	collectorTimeout        int
+	settings *sharedSettings
 
 	logger lager.Logger
 
@@ -40,6 +38,17 @@ type Scheduler struct {
 	workersRunning sync.WaitGroup
 	stoppedWorker  chan workerID
 	cancel         context.CancelFunc
+
+	restarts      map[workerID]*workerRestarts
+	restartsMutex sync.Mutex
+	restartWorker chan workerID
+
+	telemetry *telemetryRegistry
+	running   atomic.Bool
+
+	instances         *knownInstances
+	intervalOverrides *intervalOverrides
+	metricTags        *metricTags
 }
 
 // NewScheduler ...
@@ -49,31 +58,22 @@ func NewScheduler(
 	metricsEmitter emitter.MetricsEmitter,
 	logger lager.Logger,
 ) *Scheduler {
-	retryInterval := defaultRetryInterval
-	if schedulerConfig.CollectorRetryIntervalMs != nil {
-		retryInterval = *schedulerConfig.CollectorRetryIntervalMs
-	}
-	maxRetries := defaultMaxRetries
-	if schedulerConfig.CollectorMaxRetries != nil {
-		maxRetries = *schedulerConfig.CollectorMaxRetries
-	}
-	collectorTimeout := defaultCollectorTimeout
-	if schedulerConfig.CollectorTimeoutMs != nil {
-		collectorTimeout = *schedulerConfig.CollectorTimeoutMs
-	}
-
+	instances := newKnownInstances()
 	return &Scheduler{
 		brokerinfo:     brokerInfo,
 		metricsEmitter: metricsEmitter,
 
-		instanceRefreshInterval: schedulerConfig.InstanceRefreshInterval,
-		collectorRetryInterval:  retryInterval,
-		collectorMaxRetries:     maxRetries,
-		collectorTimeout:        collectorTimeout,
+		settings: &sharedSettings{settings: newSchedulerSettings(schedulerConfig)},
 
 		metricsCollectorDrivers: map[string]collector.MetricsCollectorDriver{},
 		workers:                 map[workerID]*collectorWorker{},
 		stoppedWorker:           make(chan workerID, 1),
+		restarts:                map[workerID]*workerRestarts{},
+		restartWorker:           make(chan workerID),
+		telemetry:               newTelemetryRegistry(),
+		instances:               instances,
+		intervalOverrides:       newIntervalOverrides(schedulerConfig.IntervalOverrides),
+		metricTags:              newMetricTags(instances),
 
 		logger: logger,
 	}
@@ -92,6 +92,8 @@ func (s *Scheduler) WithDriver(drivers ...collector.MetricsCollectorDriver) *Sch
 func (s *Scheduler) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
 	var ctx context.Context
 	ctx, s.cancel = context.WithCancel(context.Background())
+	s.running.Store(true)
+	defer s.running.Store(false)
 	close(ready)
 	s.mainLoop(ctx, signals)
 
@@ -122,7 +124,8 @@ func (s *Scheduler) mainLoop(ctx context.Context, signals <-chan os.Signal) {
 	for {
 		select {
 		case <-timer.C:
-			timer.Reset(time.Duration(s.instanceRefreshInterval) * time.Second)
+			timer.Reset(time.Duration(s.settings.get().instanceRefreshInterval) * time.Second)
+			s.emitTelemetry(time.Now())
 
 			instanceInfos, err := s.brokerinfo.ListInstances()
 			if err != nil {
@@ -131,6 +134,7 @@ func (s *Scheduler) mainLoop(ctx context.Context, signals <-chan os.Signal) {
 			}
 
 			s.logger.Debug("refresh_instances", lager.Data{"instances": instanceInfos})
+			s.instances.set(instanceInfos)
 
 			desiredWorkerIDs := map[workerID]brokerinfo.InstanceInfo{}
 			for _, instanceInfo := range instanceInfos {
@@ -142,8 +146,11 @@ func (s *Scheduler) mainLoop(ctx context.Context, signals <-chan os.Signal) {
 				}
 			}
 
+			s.forgetRestarts(desiredWorkerIDs)
+			s.telemetry.forget(desiredWorkerIDs)
+
 			for id, instanceInfo := range desiredWorkerIDs {
-				if _, ok := s.workers[id]; !ok {
+				if _, ok := s.workers[id]; !ok && !s.isRestartPending(id) {
 					s.startWorker(ctx, id, instanceInfo)
 				}
 			}
@@ -154,7 +161,14 @@ func (s *Scheduler) mainLoop(ctx context.Context, signals <-chan os.Signal) {
 				}
 			}
 		case id := <-s.stoppedWorker:
+			worker := s.workers[id]
 			s.deleteWorker(id)
+			if worker.failed {
+				s.scheduleRestart(ctx, worker)
+			}
+
+		case id := <-s.restartWorker:
+			s.restartPendingWorker(ctx, id)
 
 		case sig := <-signals:
 			s.logger.Debug("received-signal", lager.Data{"signal": sig})
@@ -173,9 +187,11 @@ func (s *Scheduler) startWorker(ctx context.Context, id workerID, instanceInfo b
 		instanceInfo:   instanceInfo,
 		driver:         s.metricsCollectorDrivers[id.Driver],
 		metricsEmitter: s.metricsEmitter,
-		retryInterval:  s.collectorRetryInterval,
-		maxRetries:     s.collectorMaxRetries,
-		timeout:        s.collectorTimeout,
+		settings:       s.settings,
+		telemetry:      s.telemetry,
+		instances:      s.instances,
+		intervals:      s.intervalOverrides,
+		metricTags:     s.metricTags,
 		cancel:         workerCancel,
 		logger:         s.logger,
 	}
@@ -205,12 +221,20 @@ type collectorWorker struct {
 	instanceInfo   brokerinfo.InstanceInfo
 	driver         collector.MetricsCollectorDriver
 	metricsEmitter emitter.MetricsEmitter
-	retryInterval  int
-	maxRetries     int
 	cancel         context.CancelFunc
 	logger         lager.Logger
 	collector      collector.MetricsCollector
-	timeout        int
+	settings       *sharedSettings
+	telemetry      *telemetryRegistry
+	instances      *knownInstances
+	intervals      *intervalOverrides
+	metricTags     *metricTags
+
+	// Set by the worker before it stops. failed is true if the worker
+	// gave up, as opposed to being cancelled, and collected is true if it
+	// collected metrics at least once.
+	failed    bool
+	collected bool
 }
 
 func (w *collectorWorker) run(ctx context.Context, stopped chan<- workerID) {
@@ -220,6 +244,8 @@ func (w *collectorWorker) run(ctx context.Context, stopped chan<- workerID) {
 		"driver":       w.id.Driver,
 		"instanceGUID": w.id.InstanceGUID,
 	})
+	w.telemetry.started(w.id, time.Now())
+	defer w.telemetry.stopped(w.id)
 
 	collector, err := w.driver.NewCollector(w.instanceInfo)
 	if err != nil {
@@ -227,6 +253,14 @@ func (w *collectorWorker) run(ctx context.Context, stopped chan<- workerID) {
 			"driver":       w.id.Driver,
 			"instanceGUID": w.id.InstanceGUID,
 		})
+		w.telemetry.recordError(w.id, err)
+		now := time.Now()
+		telemetry := w.telemetry.recordCollectorError(w.id, now)
+		w.metricsEmitter.EmitBatch(w.metricTags.addTo(append(
+			telemetryEnvelopes(telemetry, now),
+			collectorUpEnvelope(w.id, 0),
+		)))
+		w.failed = true
 		return
 	}
 
@@ -247,33 +281,57 @@ func (w *collectorWorker) run(ctx context.Context, stopped chan<- workerID) {
 				"instanceGUID": w.id.InstanceGUID,
 			})
 
+			settings := w.settings.get()
+			collectStart := time.Now()
 			collectedMetrics, err := func() ([]metrics.Metric, error) {
-				collectCtx, cancel := context.WithTimeout(ctx, time.Duration(w.timeout)*time.Millisecond)
+				collectCtx, cancel := context.WithTimeout(ctx, time.Duration(settings.collectorTimeout)*time.Millisecond)
 				defer cancel()
 				return collector.Collect(collectCtx)
 			}()
+			collectEnd := time.Now()
+
+			envelopes := make([]metrics.MetricEnvelope, 0, len(collectedMetrics)+1)
+			for _, metric := range collectedMetrics {
+				envelopes = append(envelopes,
+					metrics.MetricEnvelope{InstanceGUID: w.id.InstanceGUID, Metric: metric},
+				)
+			}
 
 			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
 				errorCount = errorCount + 1
-				if errorCount <= w.maxRetries {
-					waitTime := int(math.Pow(4, float64(errorCount))) * w.retryInterval
+				w.telemetry.recordError(w.id, err)
+				telemetry := w.telemetry.recordCollection(
+					w.id, collectEnd, collectEnd.Sub(collectStart), 0, errorCount, errorCount <= settings.collectorMaxRetries,
+				)
+				// The metrics returned with the error, if any, tell why
+				// the collection failed
+				envelopes = append(envelopes, collectorUpEnvelope(w.id, 0))
+				envelopes = append(envelopes, telemetryEnvelopes(telemetry, collectEnd)...)
+				w.metricsEmitter.EmitBatch(w.metricTags.addTo(envelopes))
+				if errorCount <= settings.collectorMaxRetries {
+					waitTime := int(math.Pow(4, float64(errorCount))) * settings.collectorRetryInterval
 					w.logger.Error("collect_retry",
 						err, lager.Data{
 							"errorCount":   errorCount,
-							"maxRetries":   w.maxRetries,
+							"maxRetries":   settings.collectorMaxRetries,
 							"waitTime":     waitTime,
 							"driver":       w.id.Driver,
 							"instanceGUID": w.id.InstanceGUID,
 						})
 					timer.Reset(time.Duration(waitTime) * time.Millisecond)
+					w.telemetry.scheduled(w.id, collectEnd.Add(time.Duration(waitTime)*time.Millisecond))
 				} else {
 					w.logger.Error("collect_error",
 						err, lager.Data{
 							"errorCount":   errorCount,
-							"maxRetries":   w.maxRetries,
+							"maxRetries":   settings.collectorMaxRetries,
 							"driver":       w.id.Driver,
 							"instanceGUID": w.id.InstanceGUID,
 						})
+					w.failed = true
 					return
 				}
 			} else {
@@ -282,13 +340,19 @@ func (w *collectorWorker) run(ctx context.Context, stopped chan<- workerID) {
 					"instanceGUID": w.id.InstanceGUID,
 					"metrics":      collectedMetrics,
 				})
-				for _, metric := range collectedMetrics {
-					w.metricsEmitter.Emit(
-						metrics.MetricEnvelope{InstanceGUID: w.id.InstanceGUID, Metric: metric},
-					)
-				}
 				errorCount = 0
-				timer.Reset(time.Duration(w.driver.GetCollectInterval()) * time.Second)
+				telemetry := w.telemetry.recordCollection(
+					w.id, collectEnd, collectEnd.Sub(collectStart), len(collectedMetrics), errorCount, false,
+				)
+				envelopes = append(envelopes, collectorUpEnvelope(w.id, 1))
+				envelopes = append(envelopes, telemetryEnvelopes(telemetry, collectEnd)...)
+				w.metricsEmitter.EmitBatch(w.metricTags.addTo(envelopes))
+				w.collected = true
+				interval := time.Duration(
+					w.intervals.interval(w.id, w.currentInstanceInfo(), w.driver.GetCollectInterval()),
+				) * time.Second
+				timer.Reset(interval)
+				w.telemetry.scheduled(w.id, collectEnd.Add(interval))
 			}
 		case <-ctx.Done():
 			return
@@ -296,6 +360,15 @@ func (w *collectorWorker) run(ctx context.Context, stopped chan<- workerID) {
 	}
 }
 
+// currentInstanceInfo returns the details of the instance from the last
+// refresh, or the ones the worker started with if it is no longer listed
+func (w *collectorWorker) currentInstanceInfo() brokerinfo.InstanceInfo {
+	if instanceInfo, ok := w.instances.get(w.id.InstanceGUID); ok {
+		return instanceInfo
+	}
+	return w.instanceInfo
+}
+
 // ListIntanceGUIDs ...
 func (w *Scheduler) ListIntanceGUIDs() []string {
 	instanceGUIDMap := map[string]bool{}
@@ -2,8 +2,12 @@ package scheduler
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"time"
 
 	"github.com/alphagov/paas-rds-metric-collector/pkg/brokerinfo"
@@ -49,6 +53,10 @@ func (f *fakeMetricsCollectorDriver) GetCollectInterval() int {
 	return args.Int(0)
 }
 
+func (f *fakeMetricsCollectorDriver) SetCollectInterval(intervalSeconds int) {
+	f.Called(intervalSeconds)
+}
+
 type fakeMetricsCollector struct {
 	mock.Mock
 }
@@ -63,12 +71,40 @@ func (f *fakeMetricsCollector) Close() error {
 	return args.Error(0)
 }
 
+// fakeMetricsEmitter keeps the metrics about the collectors themselves, like
+// collector_up, apart from the collected metrics
 type fakeMetricsEmitter struct {
-	envelopesReceived []metrics.MetricEnvelope
+	mutex                      sync.Mutex
+	envelopesReceived          []metrics.MetricEnvelope
+	collectorEnvelopesReceived []metrics.MetricEnvelope
 }
 
 func (f *fakeMetricsEmitter) Emit(me metrics.MetricEnvelope) {
-	f.envelopesReceived = append(f.envelopesReceived, me)
+	f.EmitBatch([]metrics.MetricEnvelope{me})
+}
+
+func (f *fakeMetricsEmitter) EmitBatch(envelopes []metrics.MetricEnvelope) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, me := range envelopes {
+		if me.Metric.Tags["source"] == "collector" {
+			f.collectorEnvelopesReceived = append(f.collectorEnvelopesReceived, me)
+		} else {
+			f.envelopesReceived = append(f.envelopesReceived, me)
+		}
+	}
+}
+
+func (f *fakeMetricsEmitter) collectorMetrics(key string) []metrics.MetricEnvelope {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	envelopes := []metrics.MetricEnvelope{}
+	for _, me := range f.collectorEnvelopesReceived {
+		if me.Metric.Key == key {
+			envelopes = append(envelopes, me)
+		}
+	}
+	return envelopes
 }
 
 var _ = Describe("collector scheduler", func() {
@@ -192,9 +228,7 @@ var _ = Describe("collector scheduler", func() {
 			"Collect",
 			mock.Anything,
 		).Return(
-			[]metrics.Metric{
-				{Key: "foo", Value: 1, Unit: "b"},
-			},
+			[]metrics.Metric(nil),
 			fmt.Errorf("error collecting metrics"),
 		)
 
@@ -208,6 +242,49 @@ var _ = Describe("collector scheduler", func() {
 		)
 	})
 
+	It("should send the metrics the collector returns together with an error", func() {
+		brokerInfo.On(
+			"ListInstances", mock.Anything,
+		).Return(
+			[]brokerinfo.InstanceInfo{
+				{GUID: "instance-guid1", Type: "fake"},
+			}, nil,
+		)
+		metricsCollectorDriver.On(
+			"NewCollector", mock.Anything,
+		).Return(
+			metricsCollector, nil,
+		)
+		metricsCollector.On(
+			"Collect",
+			mock.Anything,
+		).Return(
+			[]metrics.Metric{
+				{Key: "auth_failure", Value: 1, Unit: "bool"},
+			},
+			fmt.Errorf("error collecting metrics"),
+		)
+
+		go scheduler.Run(signals, ready)
+		defer scheduler.Stop()
+
+		Eventually(func() []metrics.MetricEnvelope {
+			metricsEmitter.mutex.Lock()
+			defer metricsEmitter.mutex.Unlock()
+			return append([]metrics.MetricEnvelope{}, metricsEmitter.envelopesReceived...)
+		}, 1*time.Second).Should(ContainElement(
+			metrics.MetricEnvelope{
+				InstanceGUID: "instance-guid1",
+				Metric:       metrics.Metric{Key: "auth_failure", Value: 1, Unit: "bool"},
+			},
+		))
+		Eventually(func() []metrics.MetricEnvelope {
+			return metricsEmitter.collectorMetrics("collector_up")
+		}, 1*time.Second).Should(ContainElement(
+			WithTransform(func(me metrics.MetricEnvelope) float64 { return me.Metric.Value }, Equal(0.0)),
+		))
+	})
+
 	Context("when collect runs for too long", func() {
 		BeforeEach(func() {
 			brokerInfo.On(
@@ -352,6 +429,502 @@ var _ = Describe("collector scheduler", func() {
 		})
 	})
 
+	Context("with a worker supervisor", func() {
+		var collectorUp metrics.MetricEnvelope
+
+		BeforeEach(func() {
+			brokerInfo.On(
+				"ListInstances", mock.Anything,
+			).Return(
+				[]brokerinfo.InstanceInfo{
+					{GUID: "instance-guid1", Type: "fake"},
+				}, nil,
+			)
+			metricsCollectorDriver.On(
+				"NewCollector", mock.Anything,
+			).Return(
+				metricsCollector, nil,
+			)
+
+			collectorUp = metrics.MetricEnvelope{
+				InstanceGUID: "instance-guid1",
+				Metric: metrics.Metric{
+					Key:   "collector_up",
+					Unit:  "bool",
+					Value: 1,
+					Tags:  map[string]string{"source": "collector", "driver": "fake"},
+				},
+			}
+		})
+
+		It("should report the collector as up while it collects metrics", func() {
+			metricsCollector.On(
+				"Collect",
+				mock.Anything,
+			).Return(
+				[]metrics.Metric{{Key: "foo", Value: 1, Unit: "b"}}, nil,
+			)
+
+			go scheduler.Run(signals, ready)
+			defer scheduler.Stop()
+
+			Eventually(func() []metrics.MetricEnvelope {
+				return metricsEmitter.collectorMetrics("collector_up")
+			}, 2*time.Second).Should(ContainElement(collectorUp))
+			Expect(scheduler.RestartCount("fake", "instance-guid1")).To(Equal(0))
+		})
+
+		It("should report the collector as down and restart the worker after it exhausts its retries", func() {
+			metricsCollector.On(
+				"Collect",
+				mock.Anything,
+			).Return(
+				[]metrics.Metric{}, fmt.Errorf("error collecting metrics"),
+			).Times(3)
+			metricsCollector.On(
+				"Collect",
+				mock.Anything,
+			).Return(
+				[]metrics.Metric{{Key: "foo", Value: 1, Unit: "b"}}, nil,
+			)
+
+			go scheduler.Run(signals, ready)
+			defer scheduler.Stop()
+
+			collectorDown := collectorUp
+			collectorDown.Metric.Value = 0
+			Eventually(func() []metrics.MetricEnvelope {
+				return metricsEmitter.collectorMetrics("collector_up")
+			}, 1*time.Second).Should(ContainElement(collectorDown))
+
+			Eventually(func() int {
+				return scheduler.RestartCount("fake", "instance-guid1")
+			}, 2*time.Second).Should(Equal(1))
+			Eventually(func() []metrics.MetricEnvelope {
+				return metricsEmitter.collectorMetrics("collector_restarts")
+			}, 1*time.Second).Should(HaveLen(1))
+			Eventually(func() []metrics.MetricEnvelope {
+				return metricsEmitter.collectorMetrics("collector_up")
+			}, 2*time.Second).Should(ContainElement(collectorUp))
+		})
+
+		It("should keep restarting a worker that cannot create its collector", func() {
+			metricsCollectorDriver.ExpectedCalls = nil
+			metricsCollectorDriver.On("GetName").Return("fake")
+			metricsCollectorDriver.On("GetCollectInterval").Return(1)
+			metricsCollectorDriver.On("SupportedTypes").Return([]string{"fake"})
+			metricsCollectorDriver.On(
+				"NewCollector", mock.Anything,
+			).Return(
+				nil, fmt.Errorf("Failed creating collector"),
+			)
+
+			go scheduler.Run(signals, ready)
+			defer scheduler.Stop()
+
+			Eventually(func() int {
+				return scheduler.RestartCount("fake", "instance-guid1")
+			}, 3*time.Second).Should(BeNumerically(">=", 1))
+		})
+
+		It("should emit the telemetry of a worker that cannot create its collector", func() {
+			metricsCollectorDriver.ExpectedCalls = nil
+			metricsCollectorDriver.On("GetName").Return("fake")
+			metricsCollectorDriver.On("GetCollectInterval").Return(1)
+			metricsCollectorDriver.On("SupportedTypes").Return([]string{"fake"})
+			metricsCollectorDriver.On(
+				"NewCollector", mock.Anything,
+			).Return(
+				nil, fmt.Errorf("Failed creating collector"),
+			)
+
+			go scheduler.Run(signals, ready)
+			defer scheduler.Stop()
+
+			collectorDown := collectorUp
+			collectorDown.Metric.Value = 0
+			Eventually(func() []metrics.MetricEnvelope {
+				return metricsEmitter.collectorMetrics("collector_up")
+			}, 2*time.Second).Should(ContainElement(collectorDown))
+			Eventually(func() []metrics.MetricEnvelope {
+				return metricsEmitter.collectorMetrics("consecutive_errors")
+			}, 2*time.Second).Should(ContainElement(
+				HaveField("Metric.Value", BeNumerically(">=", 1)),
+			))
+
+			// While the worker waits to be restarted, the scheduler keeps
+			// sending the time since the last success
+			Eventually(func() []metrics.MetricEnvelope {
+				return metricsEmitter.collectorMetrics("seconds_since_last_success")
+			}, 3*time.Second).Should(ContainElement(
+				HaveField("Metric.Value", BeNumerically(">=", 1)),
+			))
+		})
+	})
+
+	Context("with collection interval overrides", func() {
+		BeforeEach(func() {
+			brokerInfo.On(
+				"ListInstances", mock.Anything,
+			).Return(
+				[]brokerinfo.InstanceInfo{
+					{GUID: "instance-guid1", Type: "fake"},
+				}, nil,
+			)
+			metricsCollectorDriver.On(
+				"NewCollector", mock.Anything,
+			).Return(
+				metricsCollector, nil,
+			)
+			metricsCollector.On(
+				"Collect",
+				mock.Anything,
+			).Return(
+				[]metrics.Metric{{Key: "foo", Value: 1, Unit: "b"}}, nil,
+			)
+		})
+
+		countCollections := func() int {
+			count := 0
+			for _, call := range metricsCollector.Calls {
+				if call.Method == "Collect" {
+					count++
+				}
+			}
+			return count
+		}
+
+		It("should apply new overrides to the running workers", func() {
+			go scheduler.Run(signals, ready)
+			defer scheduler.Stop()
+
+			Eventually(countCollections, 3*time.Second).Should(BeNumerically(">=", 2))
+
+			retryIntervalMs := 10
+			collectorMaxRetries := 2
+			scheduler.Reconfigure(config.SchedulerConfig{
+				InstanceRefreshInterval:  1,
+				CollectorRetryIntervalMs: &retryIntervalMs,
+				CollectorMaxRetries:      &collectorMaxRetries,
+				IntervalOverrides: []config.IntervalOverrideConfig{
+					{InstanceGUID: "instance-guid1", Interval: 3600},
+				},
+			})
+			time.Sleep(1500 * time.Millisecond)
+			collections := countCollections()
+			Consistently(countCollections, 2*time.Second).Should(Equal(collections))
+
+			metricsCollectorDriver.AssertNumberOfCalls(GinkgoT(), "NewCollector", 1)
+			Expect(scheduler.Status()).To(ConsistOf(
+				And(
+					HaveField("Running", true),
+					HaveField("NextCollection", BeTemporally(">", time.Now().Add(time.Hour-time.Minute))),
+				),
+			))
+		})
+
+		It("should apply the overrides of the current tags of the instance", func() {
+			brokerInfo.ExpectedCalls = nil
+			brokerInfo.On(
+				"ListInstances", mock.Anything,
+			).Return(
+				[]brokerinfo.InstanceInfo{
+					{GUID: "instance-guid1", Type: "fake"},
+				}, nil,
+			).Once()
+			brokerInfo.On(
+				"ListInstances", mock.Anything,
+			).Return(
+				[]brokerinfo.InstanceInfo{
+					{GUID: "instance-guid1", Type: "fake", Tags: map[string]string{"team": "slow"}},
+				}, nil,
+			)
+			scheduler.intervalOverrides.set([]config.IntervalOverrideConfig{
+				{TagKey: "team", TagValue: "slow", Interval: 3600},
+			})
+
+			go scheduler.Run(signals, ready)
+			defer scheduler.Stop()
+
+			Eventually(func() []WorkerStatus {
+				return scheduler.Status()
+			}, 4*time.Second).Should(ConsistOf(
+				HaveField("NextCollection", BeTemporally(">", time.Now().Add(time.Hour-time.Minute))),
+			))
+			metricsCollectorDriver.AssertNumberOfCalls(GinkgoT(), "NewCollector", 1)
+		})
+	})
+
+	Context("with metric tags", func() {
+		BeforeEach(func() {
+			brokerInfo.On(
+				"ListInstances", mock.Anything,
+			).Return(
+				[]brokerinfo.InstanceInfo{
+					{
+						GUID:          "instance-guid1",
+						Type:          "fake",
+						Tags:          map[string]string{"Plan ID": "plan-id"},
+						InstanceClass: "db.t3.small",
+					},
+				}, nil,
+			)
+			metricsCollectorDriver.On(
+				"NewCollector", mock.Anything,
+			).Return(
+				metricsCollector, nil,
+			)
+			metricsCollector.On(
+				"Collect",
+				mock.Anything,
+			).Return(
+				[]metrics.Metric{
+					{Key: "foo", Value: 1, Unit: "b", Tags: map[string]string{"source": "sql", "plan_id": "from-query"}},
+				}, nil,
+			)
+		})
+
+		It("adds the tags of the allowlist to the metrics of the instance", func() {
+			scheduler.SetMetricTags(config.MetricTagsConfig{
+				InstanceMetadata: []string{"engine", "instance_class"},
+				InstanceTags:     []string{"Plan ID"},
+			})
+
+			go scheduler.Run(signals, ready)
+			defer scheduler.Stop()
+
+			Eventually(func() []metrics.MetricEnvelope {
+				metricsEmitter.mutex.Lock()
+				defer metricsEmitter.mutex.Unlock()
+				return append([]metrics.MetricEnvelope{}, metricsEmitter.envelopesReceived...)
+			}, 3*time.Second).Should(ContainElement(
+				metrics.MetricEnvelope{
+					InstanceGUID: "instance-guid1",
+					Metric: metrics.Metric{
+						Key: "foo", Value: 1, Unit: "b",
+						Tags: map[string]string{
+							"source":         "sql",
+							"plan_id":        "from-query",
+							"engine":         "fake",
+							"instance_class": "db.t3.small",
+						},
+					},
+				},
+			))
+
+			Eventually(func() []metrics.MetricEnvelope {
+				return metricsEmitter.collectorMetrics("collector_up")
+			}, 3*time.Second).Should(ContainElement(
+				HaveField("Metric.Tags", HaveKeyWithValue("instance_class", "db.t3.small")),
+			))
+			Expect(metricsEmitter.collectorMetrics("collected_metrics")).To(HaveEach(
+				HaveField("Metric.Tags", Not(HaveKey("instance_class"))),
+			))
+		})
+
+		It("does not add tags by default", func() {
+			go scheduler.Run(signals, ready)
+			defer scheduler.Stop()
+
+			Eventually(func() []metrics.MetricEnvelope {
+				metricsEmitter.mutex.Lock()
+				defer metricsEmitter.mutex.Unlock()
+				return append([]metrics.MetricEnvelope{}, metricsEmitter.envelopesReceived...)
+			}, 3*time.Second).Should(ContainElement(
+				HaveField("Metric.Tags", Equal(map[string]string{"source": "sql", "plan_id": "from-query"})),
+			))
+		})
+	})
+
+	Context("when it is reconfigured", func() {
+		BeforeEach(func() {
+			brokerInfo.On(
+				"ListInstances", mock.Anything,
+			).Return(
+				[]brokerinfo.InstanceInfo{
+					{GUID: "instance-guid1", Type: "fake"},
+				}, nil,
+			)
+			metricsCollectorDriver.On(
+				"NewCollector", mock.Anything,
+			).Return(
+				metricsCollector, nil,
+			)
+		})
+
+		It("should apply the new settings to the running workers", func() {
+			metricsCollector.On(
+				"Collect",
+				mock.Anything,
+			).Return(
+				[]metrics.Metric{}, fmt.Errorf("error collecting metrics"),
+			)
+
+			go scheduler.Run(signals, ready)
+			defer scheduler.Stop()
+
+			Eventually(func() []WorkerStatus {
+				return scheduler.Status()
+			}, 2*time.Second).Should(ConsistOf(
+				HaveField("LastError", "error collecting metrics"),
+			))
+
+			retryIntervalMs := 10000
+			collectorMaxRetries := 10
+			scheduler.Reconfigure(config.SchedulerConfig{
+				InstanceRefreshInterval:  1,
+				CollectorRetryIntervalMs: &retryIntervalMs,
+				CollectorMaxRetries:      &collectorMaxRetries,
+			})
+
+			Eventually(func() []WorkerStatus {
+				return scheduler.Status()
+			}, 2*time.Second).Should(ConsistOf(
+				HaveField("NextCollection", BeTemporally(">", time.Now().Add(30*time.Second))),
+			))
+			Expect(scheduler.RestartCount("fake", "instance-guid1")).To(Equal(0))
+			metricsCollectorDriver.AssertNumberOfCalls(GinkgoT(), "NewCollector", 1)
+		})
+	})
+
+	Context("with collector telemetry", func() {
+		BeforeEach(func() {
+			brokerInfo.On(
+				"ListInstances", mock.Anything,
+			).Return(
+				[]brokerinfo.InstanceInfo{
+					{GUID: "instance-guid1", Type: "fake"},
+				}, nil,
+			)
+			metricsCollectorDriver.On(
+				"NewCollector", mock.Anything,
+			).Return(
+				metricsCollector, nil,
+			)
+		})
+
+		It("should record and emit the telemetry of every collection", func() {
+			metricsCollector.On(
+				"Collect",
+				mock.Anything,
+			).Return(
+				[]metrics.Metric{}, fmt.Errorf("error collecting metrics"),
+			).Once()
+			metricsCollector.On(
+				"Collect",
+				mock.Anything,
+			).Return(
+				[]metrics.Metric{
+					{Key: "foo", Value: 1, Unit: "b"},
+					{Key: "bar", Value: 2, Unit: "b"},
+				}, nil,
+			)
+
+			go scheduler.Run(signals, ready)
+			defer scheduler.Stop()
+
+			Eventually(func() []WorkerTelemetry {
+				return scheduler.Telemetry()
+			}, 2*time.Second).Should(ConsistOf(
+				And(
+					HaveField("Driver", "fake"),
+					HaveField("InstanceGUID", "instance-guid1"),
+					HaveField("LastMetricsCount", 2),
+					HaveField("ConsecutiveErrors", 0),
+					HaveField("Retries", 1),
+					HaveField("LastSuccess", Not(BeZero())),
+				),
+			))
+
+			Eventually(func() []metrics.MetricEnvelope {
+				return metricsEmitter.collectorMetrics("collected_metrics")
+			}, 2*time.Second).Should(ContainElement(
+				metrics.MetricEnvelope{
+					InstanceGUID: TelemetrySourceID,
+					Metric: metrics.Metric{
+						Key:   "collected_metrics",
+						Unit:  "metric",
+						Value: 2,
+						Tags: map[string]string{
+							"source":        "collector",
+							"driver":        "fake",
+							"instance_guid": "instance-guid1",
+						},
+					},
+				},
+			))
+			Expect(metricsEmitter.collectorMetrics("consecutive_errors")).To(ContainElement(
+				HaveField("Metric.Value", BeNumerically("==", 1)),
+			))
+			Expect(metricsEmitter.collectorMetrics("collection_retries")).To(ContainElement(
+				HaveField("Metric.Kind", metrics.Counter),
+			))
+		})
+
+		It("should report the status of the scheduler and its workers", func() {
+			metricsCollector.On(
+				"Collect",
+				mock.Anything,
+			).Return(
+				[]metrics.Metric{}, fmt.Errorf("error collecting metrics"),
+			).Once()
+			metricsCollector.On(
+				"Collect",
+				mock.Anything,
+			).Return(
+				[]metrics.Metric{{Key: "foo", Value: 1, Unit: "b"}}, nil,
+			)
+
+			Expect(scheduler.IsRunning()).To(BeFalse())
+			go scheduler.Run(signals, ready)
+			Eventually(ready).Should(BeClosed())
+			Expect(scheduler.IsRunning()).To(BeTrue())
+
+			Eventually(func() []WorkerStatus {
+				return scheduler.Status()
+			}, 2*time.Second).Should(ConsistOf(
+				And(
+					HaveField("Driver", "fake"),
+					HaveField("InstanceGUID", "instance-guid1"),
+					HaveField("Running", true),
+					HaveField("LastCollection", Not(BeZero())),
+					HaveField("LastError", "error collecting metrics"),
+					HaveField("NextCollection", BeTemporally(">", time.Now())),
+				),
+			))
+
+			scheduler.Stop()
+			Eventually(scheduler.IsRunning).Should(BeFalse())
+		})
+
+		It("should serve the telemetry as JSON", func() {
+			metricsCollector.On(
+				"Collect",
+				mock.Anything,
+			).Return(
+				[]metrics.Metric{{Key: "foo", Value: 1, Unit: "b"}}, nil,
+			)
+
+			go scheduler.Run(signals, ready)
+			defer scheduler.Stop()
+
+			Eventually(scheduler.Telemetry, 2*time.Second).Should(HaveLen(1))
+
+			recorder := httptest.NewRecorder()
+			scheduler.TelemetryHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/telemetry", nil))
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Header().Get("Content-Type")).To(Equal("application/json"))
+
+			var telemetry []map[string]interface{}
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &telemetry)).To(Succeed())
+			Expect(telemetry).To(HaveLen(1))
+			Expect(telemetry[0]).To(HaveKeyWithValue("driver", "fake"))
+			Expect(telemetry[0]).To(HaveKeyWithValue("instance_guid", "instance-guid1"))
+			Expect(telemetry[0]).To(HaveKeyWithValue("last_metrics_count", BeNumerically("==", 1)))
+			Expect(telemetry[0]).To(HaveKey("last_success"))
+		})
+	})
+
 	Context("with working collector driver", func() {
 
 		var metricsCollectorDriverNewCollectorCall *mock.Call
@@ -973,3 +1546,99 @@ var _ = Describe("collector scheduler", func() {
 		})
 	})
 })
+
+var _ = Describe("worker restart backoff", func() {
+	It("doubles the backoff after every failure up to the maximum, with jitter", func() {
+		restartBackoffMs := 1000
+		restartMaxBackoffMs := 5000
+		scheduler := NewScheduler(
+			config.SchedulerConfig{
+				WorkerRestartBackoffMs:    &restartBackoffMs,
+				WorkerRestartMaxBackoffMs: &restartMaxBackoffMs,
+			},
+			&fakebrokerinfo.FakeBrokerInfo{},
+			&fakeMetricsEmitter{},
+			logger,
+		)
+
+		for i := 0; i < 20; i++ {
+			Expect(scheduler.restartDelay(0)).To(BeNumerically("~", 750*time.Millisecond, 250*time.Millisecond))
+			Expect(scheduler.restartDelay(1)).To(BeNumerically("~", 1500*time.Millisecond, 500*time.Millisecond))
+			Expect(scheduler.restartDelay(2)).To(BeNumerically("~", 3000*time.Millisecond, 1000*time.Millisecond))
+			Expect(scheduler.restartDelay(3)).To(BeNumerically("~", 3750*time.Millisecond, 1250*time.Millisecond))
+			Expect(scheduler.restartDelay(100)).To(BeNumerically("~", 3750*time.Millisecond, 1250*time.Millisecond))
+		}
+	})
+
+	It("uses the backoff of the new config after it is reconfigured", func() {
+		scheduler := NewScheduler(
+			config.SchedulerConfig{},
+			&fakebrokerinfo.FakeBrokerInfo{},
+			&fakeMetricsEmitter{},
+			logger,
+		)
+		Expect(scheduler.restartDelay(0)).To(BeNumerically("~", 750*time.Millisecond, 250*time.Millisecond))
+
+		restartBackoffMs := 10000
+		scheduler.Reconfigure(config.SchedulerConfig{
+			WorkerRestartBackoffMs: &restartBackoffMs,
+		})
+		Expect(scheduler.restartDelay(0)).To(BeNumerically("~", 7500*time.Millisecond, 2500*time.Millisecond))
+	})
+})
+
+var _ = Describe("collection interval overrides", func() {
+	var (
+		id           workerID
+		instanceInfo brokerinfo.InstanceInfo
+	)
+
+	BeforeEach(func() {
+		id = workerID{Driver: "postgres", InstanceGUID: "instance-guid1"}
+		instanceInfo = brokerinfo.InstanceInfo{
+			GUID: "instance-guid1",
+			Type: "postgres",
+			Tags: map[string]string{"Plan ID": "plan-large"},
+		}
+	})
+
+	It("returns the default interval if no override matches", func() {
+		overrides := newIntervalOverrides([]config.IntervalOverrideConfig{
+			{InstanceGUID: "instance-guid2", Interval: 10},
+			{TagKey: "Plan ID", TagValue: "plan-small", Interval: 20},
+			{TagKey: "Other", Interval: 30},
+			{Engine: "mysql", Interval: 40},
+			{Engine: "postgres", Driver: "cloudwatch", Interval: 50},
+		})
+		Expect(overrides.interval(id, instanceInfo, 60)).To(Equal(60))
+	})
+
+	It("prefers the instance over the tag over the engine", func() {
+		overrides := newIntervalOverrides([]config.IntervalOverrideConfig{
+			{Engine: "postgres", Interval: 10},
+		})
+		Expect(overrides.interval(id, instanceInfo, 60)).To(Equal(10))
+
+		overrides.set([]config.IntervalOverrideConfig{
+			{Engine: "postgres", Interval: 10},
+			{TagKey: "Plan ID", TagValue: "plan-large", Interval: 20},
+		})
+		Expect(overrides.interval(id, instanceInfo, 60)).To(Equal(20))
+
+		overrides.set([]config.IntervalOverrideConfig{
+			{InstanceGUID: "instance-guid1", Interval: 30},
+			{Engine: "postgres", Interval: 10},
+			{TagKey: "Plan ID", TagValue: "plan-large", Interval: 20},
+		})
+		Expect(overrides.interval(id, instanceInfo, 60)).To(Equal(30))
+	})
+
+	It("prefers an override of the driver over one of every driver", func() {
+		overrides := newIntervalOverrides([]config.IntervalOverrideConfig{
+			{Engine: "postgres", Interval: 10},
+			{Engine: "postgres", Driver: "postgres", Interval: 20},
+			{Engine: "postgres", Interval: 30},
+		})
+		Expect(overrides.interval(id, instanceInfo, 60)).To(Equal(20))
+	})
+})
@@ -0,0 +1,79 @@
+package scheduler
+
+import (
+	"sync"
+
+	"code.cloudfoundry.org/lager/v3"
+
+	"github.com/alphagov/paas-rds-metric-collector/pkg/config"
+)
+
+// schedulerSettings are the settings of the scheduler that can be changed
+// while it runs, with the defaults applied
+type schedulerSettings struct {
+	instanceRefreshInterval int
+	collectorRetryInterval  int
+	collectorMaxRetries     int
+	collectorTimeout        int
+	workerRestartBackoff    int
+	workerRestartMaxBackoff int
+}
+
+func newSchedulerSettings(schedulerConfig config.SchedulerConfig) schedulerSettings {
+	settings := schedulerSettings{
+		instanceRefreshInterval: schedulerConfig.InstanceRefreshInterval,
+		collectorRetryInterval:  defaultRetryInterval,
+		collectorMaxRetries:     defaultMaxRetries,
+		collectorTimeout:        defaultCollectorTimeout,
+		workerRestartBackoff:    defaultWorkerRestartBackoff,
+		workerRestartMaxBackoff: defaultWorkerRestartMaxBackoff,
+	}
+	if schedulerConfig.CollectorRetryIntervalMs != nil {
+		settings.collectorRetryInterval = *schedulerConfig.CollectorRetryIntervalMs
+	}
+	if schedulerConfig.CollectorMaxRetries != nil {
+		settings.collectorMaxRetries = *schedulerConfig.CollectorMaxRetries
+	}
+	if schedulerConfig.CollectorTimeoutMs != nil {
+		settings.collectorTimeout = *schedulerConfig.CollectorTimeoutMs
+	}
+	if schedulerConfig.WorkerRestartBackoffMs != nil {
+		settings.workerRestartBackoff = *schedulerConfig.WorkerRestartBackoffMs
+	}
+	if schedulerConfig.WorkerRestartMaxBackoffMs != nil {
+		settings.workerRestartMaxBackoff = *schedulerConfig.WorkerRestartMaxBackoffMs
+	}
+	return settings
+}
+
+// sharedSettings holds the current settings of the scheduler. The scheduler
+// and the workers read them every time they use them, so they can be
+// replaced while the workers run.
+type sharedSettings struct {
+	mutex    sync.RWMutex
+	settings schedulerSettings
+}
+
+func (s *sharedSettings) get() schedulerSettings {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.settings
+}
+
+func (s *sharedSettings) set(settings schedulerSettings) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.settings = settings
+}
+
+// Reconfigure applies a new config to the running scheduler. The workers use
+// the new settings and interval overrides from their next collection on, and
+// the new instance refresh interval applies from the next refresh. The
+// workers are not restarted.
+func (s *Scheduler) Reconfigure(schedulerConfig config.SchedulerConfig) {
+	s.settings.set(newSchedulerSettings(schedulerConfig))
+	s.intervalOverrides.set(schedulerConfig.IntervalOverrides)
+	s.logger.Info("reconfigured", lager.Data{"config": schedulerConfig})
+}
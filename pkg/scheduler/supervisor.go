@@ -0,0 +1,174 @@
+package scheduler
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+
+	"github.com/alphagov/paas-rds-metric-collector/pkg/brokerinfo"
+	"github.com/alphagov/paas-rds-metric-collector/pkg/metrics"
+)
+
+const defaultWorkerRestartBackoff = 1000
+const defaultWorkerRestartMaxBackoff = 300000
+
+const collectorUpMetricKey = "collector_up"
+const collectorRestartsMetricKey = "collector_restarts"
+
+// workerRestarts tracks the restarts of the worker of an instance and driver.
+//
+// failures counts the consecutive failures of the worker without collecting
+// any metric, and sets the backoff before the next restart.
+type workerRestarts struct {
+	instanceInfo brokerinfo.InstanceInfo
+	count        int
+	failures     int
+	pending      bool
+}
+
+// RestartCount returns how many times the worker of the instance and driver
+// has been restarted after failing
+func (s *Scheduler) RestartCount(driver, instanceGUID string) int {
+	s.restartsMutex.Lock()
+	defer s.restartsMutex.Unlock()
+
+	r, ok := s.restarts[workerID{Driver: driver, InstanceGUID: instanceGUID}]
+	if !ok {
+		return 0
+	}
+	return r.count
+}
+
+// scheduleRestart restarts a failed worker after a capped exponential
+// backoff with jitter. The backoff is reset if the worker collected metrics
+// before failing.
+func (s *Scheduler) scheduleRestart(ctx context.Context, worker *collectorWorker) {
+	s.restartsMutex.Lock()
+	r, ok := s.restarts[worker.id]
+	if !ok {
+		r = &workerRestarts{}
+		s.restarts[worker.id] = r
+	}
+	if worker.collected {
+		r.failures = 0
+	}
+	delay := s.restartDelay(r.failures)
+	r.failures++
+	r.pending = true
+	r.instanceInfo = worker.instanceInfo
+	s.restartsMutex.Unlock()
+
+	s.logger.Info("schedule_worker_restart", lager.Data{
+		"driver":       worker.id.Driver,
+		"instanceGUID": worker.id.InstanceGUID,
+		"delayMs":      delay.Milliseconds(),
+	})
+	s.metricsEmitter.Emit(s.metricTags.add(collectorUpEnvelope(worker.id, 0)))
+
+	time.AfterFunc(delay, func() {
+		select {
+		case s.restartWorker <- worker.id:
+		case <-ctx.Done():
+		}
+	})
+}
+
+// restartPendingWorker starts the worker again, unless the instance is gone
+// or the worker was already started
+func (s *Scheduler) restartPendingWorker(ctx context.Context, id workerID) {
+	s.restartsMutex.Lock()
+	r, ok := s.restarts[id]
+	if !ok || !r.pending {
+		s.restartsMutex.Unlock()
+		return
+	}
+	r.pending = false
+	if _, running := s.workers[id]; running {
+		s.restartsMutex.Unlock()
+		return
+	}
+	r.count++
+	count := r.count
+	instanceInfo := r.instanceInfo
+	s.restartsMutex.Unlock()
+
+	s.logger.Info("restart_worker", lager.Data{
+		"driver":       id.Driver,
+		"instanceGUID": id.InstanceGUID,
+		"restarts":     count,
+	})
+	s.metricsEmitter.Emit(s.metricTags.add(metrics.MetricEnvelope{
+		InstanceGUID: id.InstanceGUID,
+		Metric: metrics.Metric{
+			Key:   collectorRestartsMetricKey,
+			Unit:  "restart",
+			Kind:  metrics.Counter,
+			Value: float64(count),
+			Tags:  collectorTags(id),
+		},
+	}))
+	s.startWorker(ctx, id, instanceInfo)
+}
+
+// isRestartPending returns true if the supervisor will restart the worker
+func (s *Scheduler) isRestartPending(id workerID) bool {
+	s.restartsMutex.Lock()
+	defer s.restartsMutex.Unlock()
+
+	r, ok := s.restarts[id]
+	return ok && r.pending
+}
+
+// forgetRestarts drops the restarts of the workers that are no longer desired
+func (s *Scheduler) forgetRestarts(desiredWorkerIDs map[workerID]brokerinfo.InstanceInfo) {
+	s.restartsMutex.Lock()
+	defer s.restartsMutex.Unlock()
+
+	for id := range s.restarts {
+		if _, ok := desiredWorkerIDs[id]; !ok {
+			delete(s.restarts, id)
+		}
+	}
+}
+
+// restartDelay doubles the backoff for every consecutive failure, up to the
+// maximum backoff, and then picks a random delay between half and all of it.
+func (s *Scheduler) restartDelay(failures int) time.Duration {
+	settings := s.settings.get()
+	backoff := settings.workerRestartBackoff
+	for i := 0; i < failures && backoff < settings.workerRestartMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > settings.workerRestartMaxBackoff {
+		backoff = settings.workerRestartMaxBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+
+	half := backoff / 2
+	return time.Duration(half+rand.Intn(backoff-half+1)) * time.Millisecond
+}
+
+func collectorTags(id workerID) map[string]string {
+	return map[string]string{
+		"source": "collector",
+		"driver": id.Driver,
+	}
+}
+
+// collectorUpEnvelope tells if the worker of the instance and driver is
+// collecting metrics (1) or failing (0)
+func collectorUpEnvelope(id workerID, value float64) metrics.MetricEnvelope {
+	return metrics.MetricEnvelope{
+		InstanceGUID: id.InstanceGUID,
+		Metric: metrics.Metric{
+			Key:   collectorUpMetricKey,
+			Unit:  "bool",
+			Value: value,
+			Tags:  collectorTags(id),
+		},
+	}
+}
@@ -0,0 +1,285 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/alphagov/paas-rds-metric-collector/pkg/brokerinfo"
+	"github.com/alphagov/paas-rds-metric-collector/pkg/metrics"
+)
+
+// TelemetrySourceID is the source of the metrics about the collector itself,
+// so they are not mixed with the metrics of the tenants' instances
+const TelemetrySourceID = "rds-metric-collector"
+
+// WorkerTelemetry describes the recent collections of the worker of an
+// instance and driver
+type WorkerTelemetry struct {
+	Driver                 string    `json:"driver"`
+	InstanceGUID           string    `json:"instance_guid"`
+	LastCollectionDuration float64   `json:"last_collection_duration_seconds"`
+	LastMetricsCount       int       `json:"last_metrics_count"`
+	ConsecutiveErrors      int       `json:"consecutive_errors"`
+	Retries                int       `json:"retries"`
+	LastCollection         time.Time `json:"last_collection"`
+	LastSuccess            time.Time `json:"last_success"`
+
+	started time.Time
+}
+
+// SecondsSinceLastSuccess returns the time since the last successful
+// collection, or since the worker first started if there was none
+func (t WorkerTelemetry) SecondsSinceLastSuccess(now time.Time) float64 {
+	since := t.LastSuccess
+	if since.IsZero() {
+		since = t.started
+	}
+	return now.Sub(since).Seconds()
+}
+
+// WorkerStatus describes the state of the worker of an instance and driver.
+// A worker that is not running is waiting to be restarted.
+type WorkerStatus struct {
+	Driver         string    `json:"driver"`
+	InstanceGUID   string    `json:"instance_guid"`
+	Running        bool      `json:"running"`
+	LastCollection time.Time `json:"last_collection"`
+	LastError      string    `json:"last_error,omitempty"`
+	NextCollection time.Time `json:"next_collection"`
+	Restarts       int       `json:"restarts"`
+}
+
+type workerRecord struct {
+	telemetry      WorkerTelemetry
+	running        bool
+	lastError      string
+	nextCollection time.Time
+}
+
+type telemetryRegistry struct {
+	mutex   sync.Mutex
+	workers map[workerID]*workerRecord
+}
+
+func newTelemetryRegistry() *telemetryRegistry {
+	return &telemetryRegistry{
+		workers: map[workerID]*workerRecord{},
+	}
+}
+
+func (r *telemetryRegistry) record(id workerID, now time.Time) *workerRecord {
+	w, ok := r.workers[id]
+	if !ok {
+		w = &workerRecord{
+			telemetry: WorkerTelemetry{
+				Driver:       id.Driver,
+				InstanceGUID: id.InstanceGUID,
+				started:      now,
+			},
+		}
+		r.workers[id] = w
+	}
+	return w
+}
+
+// started registers the worker, keeping the telemetry of its previous runs
+func (r *telemetryRegistry) started(id workerID, now time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	w := r.record(id, now)
+	w.running = true
+	w.nextCollection = now
+}
+
+// stopped records that the worker is no longer running
+func (r *telemetryRegistry) stopped(id workerID) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if w, ok := r.workers[id]; ok {
+		w.running = false
+		w.nextCollection = time.Time{}
+	}
+}
+
+// recordCollection updates the telemetry of the worker after a collection
+// and returns a copy of it
+func (r *telemetryRegistry) recordCollection(
+	id workerID,
+	finished time.Time,
+	duration time.Duration,
+	metricsCount int,
+	consecutiveErrors int,
+	retried bool,
+) WorkerTelemetry {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	t := &r.record(id, finished).telemetry
+	t.LastCollection = finished
+	t.LastCollectionDuration = duration.Seconds()
+	t.LastMetricsCount = metricsCount
+	t.ConsecutiveErrors = consecutiveErrors
+	if consecutiveErrors == 0 {
+		t.LastSuccess = finished
+	}
+	if retried {
+		t.Retries++
+	}
+	return *t
+}
+
+// recordCollectorError counts a failure to create the collector of the
+// worker as a failed collection, and returns a copy of the telemetry
+func (r *telemetryRegistry) recordCollectorError(id workerID, now time.Time) WorkerTelemetry {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	t := &r.record(id, now).telemetry
+	t.ConsecutiveErrors++
+	return *t
+}
+
+// recordError keeps the error of the last failed collection
+func (r *telemetryRegistry) recordError(id workerID, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if w, ok := r.workers[id]; ok {
+		w.lastError = err.Error()
+	}
+}
+
+// scheduled records when the worker will collect metrics next
+func (r *telemetryRegistry) scheduled(id workerID, next time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if w, ok := r.workers[id]; ok {
+		w.nextCollection = next
+	}
+}
+
+// forget drops the telemetry of the workers that are no longer desired
+func (r *telemetryRegistry) forget(desiredWorkerIDs map[workerID]brokerinfo.InstanceInfo) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for id := range r.workers {
+		if _, ok := desiredWorkerIDs[id]; !ok {
+			delete(r.workers, id)
+		}
+	}
+}
+
+func (r *telemetryRegistry) list() []WorkerTelemetry {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	telemetry := make([]WorkerTelemetry, 0, len(r.workers))
+	for _, w := range r.workers {
+		telemetry = append(telemetry, w.telemetry)
+	}
+	sort.Slice(telemetry, func(i, j int) bool {
+		if telemetry[i].InstanceGUID != telemetry[j].InstanceGUID {
+			return telemetry[i].InstanceGUID < telemetry[j].InstanceGUID
+		}
+		return telemetry[i].Driver < telemetry[j].Driver
+	})
+	return telemetry
+}
+
+func (r *telemetryRegistry) status() []WorkerStatus {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	status := make([]WorkerStatus, 0, len(r.workers))
+	for _, w := range r.workers {
+		status = append(status, WorkerStatus{
+			Driver:         w.telemetry.Driver,
+			InstanceGUID:   w.telemetry.InstanceGUID,
+			Running:        w.running,
+			LastCollection: w.telemetry.LastCollection,
+			LastError:      w.lastError,
+			NextCollection: w.nextCollection,
+		})
+	}
+	sort.Slice(status, func(i, j int) bool {
+		if status[i].InstanceGUID != status[j].InstanceGUID {
+			return status[i].InstanceGUID < status[j].InstanceGUID
+		}
+		return status[i].Driver < status[j].Driver
+	})
+	return status
+}
+
+// Status returns the state of every worker, sorted by instance and driver
+func (s *Scheduler) Status() []WorkerStatus {
+	status := s.telemetry.status()
+	for i := range status {
+		status[i].Restarts = s.RestartCount(status[i].Driver, status[i].InstanceGUID)
+	}
+	return status
+}
+
+// IsRunning returns true while the scheduler runs
+func (s *Scheduler) IsRunning() bool {
+	return s.running.Load()
+}
+
+// Telemetry returns the telemetry of every worker, sorted by instance and
+// driver
+func (s *Scheduler) Telemetry() []WorkerTelemetry {
+	return s.telemetry.list()
+}
+
+// TelemetryHandler serves the telemetry of every worker as JSON
+func (s *Scheduler) TelemetryHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(s.Telemetry()); err != nil {
+			s.logger.Error("encoding_telemetry", err)
+		}
+	})
+}
+
+// emitTelemetry sends the telemetry of every known worker, including the ones
+// waiting to be restarted, so that seconds_since_last_success keeps growing
+// while an instance is not collected
+func (s *Scheduler) emitTelemetry(now time.Time) {
+	envelopes := []metrics.MetricEnvelope{}
+	for _, t := range s.telemetry.list() {
+		envelopes = append(envelopes, telemetryEnvelopes(t, now)...)
+	}
+	if len(envelopes) > 0 {
+		s.metricsEmitter.EmitBatch(envelopes)
+	}
+}
+
+// telemetryEnvelopes returns the telemetry of a worker as metrics of the
+// TelemetrySourceID source
+func telemetryEnvelopes(t WorkerTelemetry, now time.Time) []metrics.MetricEnvelope {
+	tags := map[string]string{
+		"source":        "collector",
+		"driver":        t.Driver,
+		"instance_guid": t.InstanceGUID,
+	}
+	telemetryMetrics := []metrics.Metric{
+		{Key: "collection_duration", Unit: "s", Value: t.LastCollectionDuration},
+		{Key: "collected_metrics", Unit: "metric", Value: float64(t.LastMetricsCount)},
+		{Key: "consecutive_errors", Unit: "error", Value: float64(t.ConsecutiveErrors)},
+		{Key: "collection_retries", Unit: "retry", Kind: metrics.Counter, Value: float64(t.Retries)},
+		{Key: "seconds_since_last_success", Unit: "s", Value: t.SecondsSinceLastSuccess(now)},
+	}
+
+	envelopes := make([]metrics.MetricEnvelope, 0, len(telemetryMetrics))
+	for _, m := range telemetryMetrics {
+		m.Tags = tags
+		envelopes = append(envelopes, metrics.MetricEnvelope{InstanceGUID: TelemetrySourceID, Metric: m})
+	}
+	return envelopes
+}
@@ -0,0 +1,143 @@
+package status
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync/atomic"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/tedsuo/ifrit"
+
+	"github.com/alphagov/paas-rds-metric-collector/pkg/scheduler"
+)
+
+// ReadinessTracker wraps an ifrit.Runner to tell if it is ready and still
+// running. Wrapping the locket lock runner tells if the lock is held.
+type ReadinessTracker struct {
+	runner ifrit.Runner
+	ready  atomic.Bool
+}
+
+// NewReadinessTracker ...
+func NewReadinessTracker(runner ifrit.Runner) *ReadinessTracker {
+	return &ReadinessTracker{runner: runner}
+}
+
+// Run runs the wrapped runner
+func (t *ReadinessTracker) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	innerReady := make(chan struct{})
+	errs := make(chan error, 1)
+	go func() {
+		errs <- t.runner.Run(signals, innerReady)
+	}()
+
+	select {
+	case <-innerReady:
+		t.ready.Store(true)
+		close(ready)
+	case err := <-errs:
+		return err
+	}
+
+	err := <-errs
+	t.ready.Store(false)
+	return err
+}
+
+// IsReady returns true after the wrapped runner is ready and until it exits
+func (t *ReadinessTracker) IsReady() bool {
+	return t.ready.Load()
+}
+
+// Lock tells if the collector holds the lock to collect metrics
+type Lock interface {
+	IsReady() bool
+}
+
+// Scheduler tells the state of the scheduler and its workers
+type Scheduler interface {
+	IsRunning() bool
+	Status() []scheduler.WorkerStatus
+}
+
+// Emitters tells how many envelopes each emitter failed to deliver
+type Emitters interface {
+	DeliveryFailures() map[string]uint64
+}
+
+// EmittersStatus is the body of the /emitters endpoint
+type EmittersStatus struct {
+	DeliveryFailures map[string]uint64 `json:"delivery_failures"`
+}
+
+// Health is the body of the /health endpoint
+type Health struct {
+	LockHeld         bool `json:"lock_held"`
+	SchedulerRunning bool `json:"scheduler_running"`
+}
+
+// Healthy returns false if the lock is held but the scheduler is not
+// running. A collector without the lock is a healthy standby.
+func (h Health) Healthy() bool {
+	return !h.LockHeld || h.SchedulerRunning
+}
+
+// Handler serves /health, /status and /emitters. The emitters are nil if
+// the delivery failures are not tracked, with a single emitter.
+type Handler struct {
+	lock      Lock
+	scheduler Scheduler
+	emitters  Emitters
+	logger    lager.Logger
+}
+
+// NewHandler ...
+func NewHandler(lock Lock, scheduler Scheduler, emitters Emitters, logger lager.Logger) *Handler {
+	return &Handler{
+		lock:      lock,
+		scheduler: scheduler,
+		emitters:  emitters,
+		logger:    logger,
+	}
+}
+
+// Register adds the endpoints to the mux
+func (h *Handler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/health", h.serveHealth)
+	mux.HandleFunc("/status", h.serveStatus)
+	mux.HandleFunc("/emitters", h.serveEmitters)
+}
+
+func (h *Handler) serveHealth(w http.ResponseWriter, r *http.Request) {
+	health := Health{
+		LockHeld:         h.lock.IsReady(),
+		SchedulerRunning: h.scheduler.IsRunning(),
+	}
+
+	statusCode := http.StatusOK
+	if !health.Healthy() {
+		statusCode = http.StatusServiceUnavailable
+	}
+	h.writeJSON(w, statusCode, health)
+}
+
+func (h *Handler) serveStatus(w http.ResponseWriter, r *http.Request) {
+	h.writeJSON(w, http.StatusOK, h.scheduler.Status())
+}
+
+func (h *Handler) serveEmitters(w http.ResponseWriter, r *http.Request) {
+	emittersStatus := EmittersStatus{DeliveryFailures: map[string]uint64{}}
+	if h.emitters != nil {
+		emittersStatus.DeliveryFailures = h.emitters.DeliveryFailures()
+	}
+	h.writeJSON(w, http.StatusOK, emittersStatus)
+}
+
+func (h *Handler) writeJSON(w http.ResponseWriter, statusCode int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		h.logger.Error("encoding_response", err)
+	}
+}
@@ -0,0 +1,193 @@
+package status_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"time"
+
+	"github.com/tedsuo/ifrit"
+
+	"github.com/alphagov/paas-rds-metric-collector/pkg/scheduler"
+	"github.com/alphagov/paas-rds-metric-collector/pkg/status"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type fakeLock struct {
+	held bool
+}
+
+func (f *fakeLock) IsReady() bool {
+	return f.held
+}
+
+type fakeScheduler struct {
+	running bool
+	status  []scheduler.WorkerStatus
+}
+
+func (f *fakeScheduler) IsRunning() bool {
+	return f.running
+}
+
+func (f *fakeScheduler) Status() []scheduler.WorkerStatus {
+	return f.status
+}
+
+type fakeEmitters struct {
+	failures map[string]uint64
+}
+
+func (f *fakeEmitters) DeliveryFailures() map[string]uint64 {
+	return f.failures
+}
+
+var _ = Describe("ReadinessTracker", func() {
+	It("is ready while the wrapped runner is ready", func() {
+		becomeReady := make(chan struct{})
+		tracker := status.NewReadinessTracker(ifrit.RunFunc(func(signals <-chan os.Signal, ready chan<- struct{}) error {
+			<-becomeReady
+			close(ready)
+			<-signals
+			return nil
+		}))
+
+		process := ifrit.Background(tracker)
+		Consistently(tracker.IsReady, 100*time.Millisecond).Should(BeFalse())
+
+		close(becomeReady)
+		Eventually(process.Ready()).Should(BeClosed())
+		Expect(tracker.IsReady()).To(BeTrue())
+
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive(BeNil()))
+		Expect(tracker.IsReady()).To(BeFalse())
+	})
+
+	It("returns the error of the wrapped runner if it fails before being ready", func() {
+		tracker := status.NewReadinessTracker(ifrit.RunFunc(func(signals <-chan os.Signal, ready chan<- struct{}) error {
+			return errors.New("cannot connect")
+		}))
+
+		process := ifrit.Background(tracker)
+		Eventually(process.Wait()).Should(Receive(MatchError("cannot connect")))
+		Expect(tracker.IsReady()).To(BeFalse())
+	})
+})
+
+var _ = Describe("Handler", func() {
+	var (
+		lock            *fakeLock
+		schedulerStatus *fakeScheduler
+		emitters        status.Emitters
+		mux             *http.ServeMux
+	)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		return recorder
+	}
+
+	BeforeEach(func() {
+		lock = &fakeLock{}
+		schedulerStatus = &fakeScheduler{}
+		emitters = &fakeEmitters{failures: map[string]uint64{"loggregator": 3, "prometheus": 0}}
+	})
+
+	JustBeforeEach(func() {
+		mux = http.NewServeMux()
+		status.NewHandler(lock, schedulerStatus, emitters, logger).Register(mux)
+	})
+
+	Describe("/health", func() {
+		It("is healthy while waiting for the lock", func() {
+			response := get("/health")
+			Expect(response.Code).To(Equal(http.StatusOK))
+			Expect(response.Header().Get("Content-Type")).To(Equal("application/json"))
+			Expect(response.Body.String()).To(MatchJSON(`{"lock_held": false, "scheduler_running": false}`))
+		})
+
+		It("is healthy if it holds the lock and the scheduler is running", func() {
+			lock.held = true
+			schedulerStatus.running = true
+
+			response := get("/health")
+			Expect(response.Code).To(Equal(http.StatusOK))
+			Expect(response.Body.String()).To(MatchJSON(`{"lock_held": true, "scheduler_running": true}`))
+		})
+
+		It("is unhealthy if it holds the lock but the scheduler is not running", func() {
+			lock.held = true
+
+			response := get("/health")
+			Expect(response.Code).To(Equal(http.StatusServiceUnavailable))
+			Expect(response.Body.String()).To(MatchJSON(`{"lock_held": true, "scheduler_running": false}`))
+		})
+	})
+
+	Describe("/status", func() {
+		It("lists the workers", func() {
+			lastCollection := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+			schedulerStatus.status = []scheduler.WorkerStatus{
+				{
+					Driver:         "postgres",
+					InstanceGUID:   "instance-guid1",
+					Running:        true,
+					LastCollection: lastCollection,
+					LastError:      "connection refused",
+					NextCollection: lastCollection.Add(time.Minute),
+					Restarts:       2,
+				},
+			}
+
+			response := get("/status")
+			Expect(response.Code).To(Equal(http.StatusOK))
+
+			var body []map[string]interface{}
+			Expect(json.Unmarshal(response.Body.Bytes(), &body)).To(Succeed())
+			Expect(body).To(Equal([]map[string]interface{}{
+				{
+					"driver":          "postgres",
+					"instance_guid":   "instance-guid1",
+					"running":         true,
+					"last_collection": "2020-01-02T03:04:05Z",
+					"last_error":      "connection refused",
+					"next_collection": "2020-01-02T03:05:05Z",
+					"restarts":        float64(2),
+				},
+			}))
+		})
+
+		It("returns an empty list if there are no workers", func() {
+			schedulerStatus.status = []scheduler.WorkerStatus{}
+
+			response := get("/status")
+			Expect(response.Body.String()).To(MatchJSON(`[]`))
+		})
+	})
+
+	Describe("/emitters", func() {
+		It("returns the delivery failures of every emitter", func() {
+			response := get("/emitters")
+			Expect(response.Code).To(Equal(http.StatusOK))
+			Expect(response.Body.String()).To(MatchJSON(`{"delivery_failures": {"loggregator": 3, "prometheus": 0}}`))
+		})
+
+		Context("when the delivery failures are not tracked", func() {
+			BeforeEach(func() {
+				emitters = nil
+			})
+
+			It("returns no delivery failures", func() {
+				response := get("/emitters")
+				Expect(response.Code).To(Equal(http.StatusOK))
+				Expect(response.Body.String()).To(MatchJSON(`{"delivery_failures": {}}`))
+			})
+		})
+	})
+})
@@ -58,4 +58,31 @@ var _ = Describe("utils", func() {
 			Expect(str1).ToNot(Equal(str2))
 		})
 	})
+	Context("ParseVersion", func() {
+		It("parses PostgreSQL and MySQL server versions", func() {
+			for s, expected := range map[string]Version{
+				"13":                             {13, 0, 0},
+				"13.4":                           {13, 4, 0},
+				"9.6.21":                         {9, 6, 21},
+				"12.5 (Debian 12.5-1.pgdg100+1)": {12, 5, 0},
+				"8.0.28-log":                     {8, 0, 28},
+			} {
+				v, err := ParseVersion(s)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(v).To(Equal(expected), s)
+			}
+		})
+
+		It("fails if there is no version number", func() {
+			_, err := ParseVersion("latest")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("compares versions", func() {
+			Expect(Version{9, 6, 21}.Compare(Version{10, 0, 0})).To(Equal(-1))
+			Expect(Version{13, 4, 0}.Compare(Version{13, 4, 0})).To(Equal(0))
+			Expect(Version{8, 0, 28}.Compare(Version{8, 0, 3})).To(Equal(1))
+			Expect(Version{8, 0, 28}.String()).To(Equal("8.0.28"))
+		})
+	})
 })
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var versionRegexp = regexp.MustCompile(`^\s*(\d+)(?:\.(\d+))?(?:\.(\d+))?`)
+
+// Version is a database server version as major, minor and patch numbers
+type Version [3]int
+
+// ParseVersion reads the version number at the start of a string like
+// "13.4", "9.6.21", "12.5 (Debian 12.5-1.pgdg100+1)" or "8.0.28-log".
+// Missing minor or patch numbers are read as 0.
+func ParseVersion(s string) (Version, error) {
+	var v Version
+
+	match := versionRegexp.FindStringSubmatch(s)
+	if match == nil {
+		return v, fmt.Errorf("cannot parse version '%s'", s)
+	}
+	for i, part := range match[1:] {
+		if part == "" {
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return v, fmt.Errorf("cannot parse version '%s': %s", s, err)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+// Compare returns -1, 0 or 1 if v is lower than, equal to or greater than other
+func (v Version) Compare(other Version) int {
+	for i := range v {
+		if v[i] < other[i] {
+			return -1
+		}
+		if v[i] > other[i] {
+			return 1
+		}
+	}
+	return 0
+}
+
+func (v Version) String() string {
+	parts := make([]string, len(v))
+	for i, n := range v {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ".")
+}